@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	roleAdmin    = "admin"
+	roleOperator = "operator"
+	roleAgent    = "agent"
+	roleCustomer = "customer"
+
+	tokenTTL = 24 * time.Hour
+)
+
+// staffRoles may see and manage every customer's bookings
+var staffRoles = []string{roleAdmin, roleOperator, roleAgent}
+
+var jwtSecret []byte
+
+type User struct {
+	gorm.Model
+	Email        string `json:"email" gorm:"uniqueIndex;size:191"`
+	PasswordHash string `json:"-"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	Role         string `json:"role" gorm:"default:customer"`
+}
+
+type RegisterRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// initAuth loads the JWT secret and creates the first admin account if configured
+func initAuth(users UserRepository) {
+	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		jwtSecret = make([]byte, 32)
+		if _, err := rand.Read(jwtSecret); err != nil {
+			log.Fatal("Failed to generate JWT secret:", err)
+		}
+		log.Println("JWT_SECRET not set, using a temporary secret; sessions end on restart")
+	}
+
+	setupInitialAdmin(users)
+}
+
+// Function to create the admin account from ADMIN_EMAIL and ADMIN_PASSWORD if it doesn't exist
+func setupInitialAdmin(users UserRepository) {
+	email := strings.ToLower(os.Getenv("ADMIN_EMAIL"))
+	password := os.Getenv("ADMIN_PASSWORD")
+	if email == "" || password == "" {
+		return
+	}
+
+	_, err := users.FindUserByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Fatal("Failed to hash admin password:", err)
+		}
+		user := User{Email: email, PasswordHash: string(hash), FirstName: "Admin", Role: roleAdmin}
+		if err := users.CreateUser(&user); err != nil {
+			log.Fatal("Failed to create admin user:", err)
+		}
+		log.Println("Admin user created")
+	}
+}
+
+func validRole(role string) bool {
+	switch role {
+	case roleAdmin, roleOperator, roleAgent, roleCustomer:
+		return true
+	}
+	return false
+}
+
+// issueToken creates a signed session token for the user
+func issueToken(user User) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":  strconv.FormatUint(uint64(user.ID), 10),
+		"role": user.Role,
+		"typ":  "session",
+		"iat":  time.Now().Unix(),
+		"exp":  time.Now().Add(tokenTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// parseToken validates a token of the given type and returns its subject
+func parseToken(raw, typ string) (string, error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != typ {
+		return "", errors.New("unexpected token type")
+	}
+	return claims.GetSubject()
+}
+
+// AuthMiddleware loads the signed-in user from the Authorization header, if any.
+// Requests without a token continue as guests; invalid tokens are rejected.
+func AuthMiddleware(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		raw, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header"})
+			return
+		}
+
+		sub, err := parseToken(raw, "session")
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		userID, err := strconv.ParseUint(sub, 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+
+		// Load the user so role changes and deletions take effect immediately
+		user, err := users.GetUser(uint(userID))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+// RequireRole only lets signed-in users with one of the given roles through
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if len(roles) > 0 && !hasRole(user, roles...) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentUser returns the signed-in user, if any
+func currentUser(c *gin.Context) (User, bool) {
+	v, ok := c.Get("user")
+	if !ok {
+		return User{}, false
+	}
+	user, ok := v.(User)
+	return user, ok
+}
+
+// currentUserID returns the ID of the signed-in user, or nil for guests
+func currentUserID(c *gin.Context) *uint {
+	user, ok := currentUser(c)
+	if !ok {
+		return nil
+	}
+	return &user.ID
+}
+
+func hasRole(user User, roles ...string) bool {
+	for _, r := range roles {
+		if user.Role == r {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageBooking reports whether the caller is staff, owns the booking, or
+// holds a guest link for the email the booking was made with
+func canManageBooking(c *gin.Context, ownerID *uint, email string) bool {
+	if guestEmail, ok := currentGuestEmail(c); ok {
+		return strings.EqualFold(guestEmail, email)
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return false
+	}
+	if hasRole(user, staffRoles...) {
+		return true
+	}
+	return ownerID != nil && *ownerID == user.ID
+}
+
+// callerCanManage binds canManageBooking to the request, for repositories that check
+// access inside their transaction
+func callerCanManage(c *gin.Context) BookingAccess {
+	return func(ownerID *uint, email string) bool {
+		return canManageBooking(c, ownerID, email)
+	}
+}
+
+// Handler to register a customer account
+func registerHandler(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !ValidateUserInput(req.FirstName, req.LastName, req.Email, 1) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+		if len(req.Password) < 8 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters"})
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+
+		user := User{
+			Email:        strings.ToLower(strings.TrimSpace(req.Email)),
+			PasswordHash: string(hash),
+			FirstName:    req.FirstName,
+			LastName:     req.LastName,
+			Role:         roleCustomer,
+		}
+		if _, err := users.FindUserByEmail(user.Email); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+		if err := users.CreateUser(&user); err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account"})
+			return
+		}
+
+		token, err := issueToken(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"user": user, "token": token})
+	}
+}
+
+// Handler to sign in with email and password
+func loginHandler(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		user, err := users.FindUserByEmail(strings.ToLower(strings.TrimSpace(req.Email)))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			return
+		}
+
+		token, err := issueToken(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"user": user, "token": token})
+	}
+}
+
+// Handler to get the signed-in user
+func meHandler(c *gin.Context) {
+	user, _ := currentUser(c)
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// Handler to list all user accounts
+func getAllUsers(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := users.ListUsers()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"users": list})
+	}
+}
+
+// Handler to change the role of a user
+func setUserRoleHandler(users UserRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil || !validRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		user, err := users.SetUserRole(id, req.Role)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AttendeeRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// attendeeName returns the name printed on a conference ticket, falling back to the purchaser
+func attendeeName(ticket ConferenceTicket, booking ConferenceBooking) string {
+	if ticket.AttendeeName != "" {
+		return ticket.AttendeeName
+	}
+	return booking.FirstName + " " + booking.LastName
+}
+
+// Handler to list the individual tickets of a conference booking
+func getConferenceBookingTickets(bookings BookingRepository, tickets TicketRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bookingID, ok := idParam(c, "bookingId")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		booking, err := bookings.GetConferenceBooking(bookingID)
+		if err != nil || strconv.FormatUint(uint64(booking.ConferenceID), 10) != c.Param("id") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		if !canManageBooking(c, booking.UserID, booking.Email) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot view these tickets"})
+			return
+		}
+
+		list, err := tickets.ConferenceTickets(booking.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tickets"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tickets": list})
+	}
+}
+
+// Handler to assign or reassign the attendee of a conference ticket
+func assignAttendeeHandler(tickets TicketRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AttendeeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Email = strings.TrimSpace(req.Email)
+		if len(req.Name) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Attendee name is required"})
+			return
+		}
+		if req.Email != "" && (len(req.Email) <= 3 || !strings.Contains(req.Email, "@")) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attendee email"})
+			return
+		}
+		conferenceID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+			return
+		}
+
+		ticket, err := tickets.AssignAttendee(conferenceID, c.Param("ticketId"), req.Name, req.Email, callerCanManage(c))
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+			return
+		case errors.Is(err, errNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot change this ticket"})
+			return
+		case err != nil:
+			respondBookingError(c, err, "Failed to assign attendee")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ticket": ticket})
+	}
+}
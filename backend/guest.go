@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const guestLinkTTL = 30 * time.Minute
+
+type GuestLookupRequest struct {
+	Email string `json:"email"`
+}
+
+// issueGuestToken creates a short lived token granting access to the bookings of an email
+func issueGuestToken(email string) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": email,
+		"typ": "guest",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(guestLinkTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// guestLink builds the link sent to guests, pointing at APP_URL
+func guestLink(token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return strings.TrimRight(base, "/") + "/my-bookings?token=" + url.QueryEscape(token)
+}
+
+// guestLinkBody renders the email with a fresh guest link. It is built at delivery so the
+// outbox never stores a link that would let whoever reads or replays it in.
+func guestLinkBody(email string) (string, error) {
+	token, err := issueGuestToken(email)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Hi,\n\nUse this link to view and manage your bookings:\n\n%s\n\nThe link expires in %d minutes.\n",
+		guestLink(token), int(guestLinkTTL.Minutes())), nil
+}
+
+// GuestMiddleware only lets requests with a valid guest link token through.
+// The token is read from the token query parameter or the X-Guest-Token header.
+func GuestMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw := c.Query("token")
+		if raw == "" {
+			raw = c.GetHeader("X-Guest-Token")
+		}
+		if raw == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Guest token required"})
+			return
+		}
+
+		email, err := parseToken(raw, "guest")
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "This link is invalid or has expired"})
+			return
+		}
+		c.Set("guestEmail", email)
+		c.Next()
+	}
+}
+
+// currentGuestEmail returns the email of a guest link, if the request carries one
+func currentGuestEmail(c *gin.Context) (string, bool) {
+	v, ok := c.Get("guestEmail")
+	if !ok {
+		return "", false
+	}
+	email, ok := v.(string)
+	return email, ok
+}
+
+// Handler to email a guest a link to their bookings
+func guestLookupHandler(bookings BookingRepository, outbox *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req GuestLookupRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		email := strings.ToLower(strings.TrimSpace(req.Email))
+		if len(email) <= 3 || !strings.Contains(email, "@") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A valid email is required"})
+			return
+		}
+
+		// Same answer whether or not bookings exist, so emails cannot be probed
+		response := gin.H{"message": "If we have bookings for this email, a link is on its way"}
+
+		found, err := bookings.EmailBookings(email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send link"})
+			return
+		}
+		if len(found.Bus)+len(found.Conferences) == 0 {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		// The link itself is only made when the message is sent, see guestLinkBody
+		msg := Message{To: email, Subject: "Your bookings"}
+		if err := enqueueMessage(outbox, "guest_link", "", msg); err != nil {
+			log.Printf("Failed to queue guest link for %s: %v", email, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send link"})
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Handler to list the bookings made with the email of a guest link
+func getGuestBookings(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, _ := currentGuestEmail(c)
+
+		list, err := bookings.EmailBookings(email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+			return
+		}
+		result := customerBookings("/api/guest", list)
+		result["email"] = email
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGuestLookup(t *testing.T) {
+	db := openMemoryDB(t)
+	sent := useTestDB(t, db)
+	r := newTestRouter(db)
+	admin := signIn(t, db, "admin@example.com", roleAdmin)
+	trip := createTestTrip(t, newRepositories(db), "Guest Coach", 4)
+	bookTestSeat(t, db, r, trip, 1, "gwen@example.com")
+	db.Where("1 = 1").Delete(&OutboxMessage{})
+
+	// Unknown emails get the same answer but no message
+	for _, email := range []string{"nobody@example.com", " Gwen@Example.com "} {
+		if status := doJSON(t, r, http.MethodPost, "/api/guest/lookup", GuestLookupRequest{Email: email}, nil, nil); status != http.StatusOK {
+			t.Fatalf("lookup %q: status %d", email, status)
+		}
+	}
+	var queued []OutboxMessage
+	db.Find(&queued)
+	if len(queued) != 1 || queued[0].Recipient != "gwen@example.com" || queued[0].Kind != "guest_link" {
+		t.Fatalf("queued %+v, want one guest link for gwen@example.com", queued)
+	}
+
+	// Admins see the queued message but not a link they could use
+	var listed struct {
+		Messages []OutboxMessage `json:"messages"`
+	}
+	doJSON(t, r, http.MethodGet, "/api/admin/outbox?status=pending", nil, admin, &listed)
+	if len(listed.Messages) != 1 || strings.Contains(listed.Messages[0].Body, "token=") {
+		t.Fatalf("admin outbox listing %+v exposes the guest link", listed.Messages)
+	}
+
+	if processed := deliverOutbox(db); processed != 1 {
+		t.Fatalf("delivered %d messages, want 1", processed)
+	}
+	messages := sent.Messages()
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		t.Fatalf("sent message has no link:\n%s", messages[len(messages)-1].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("unescape token: %v", err)
+	}
+	var stored OutboxMessage
+	db.First(&stored, queued[0].ID)
+	if strings.Contains(stored.Body, token) {
+		t.Fatal("the sent link was stored in the outbox")
+	}
+
+	var bookings struct {
+		Email string `json:"email"`
+		Bus   struct {
+			Upcoming []interface{} `json:"upcoming"`
+		} `json:"bus"`
+	}
+	status := doJSON(t, r, http.MethodGet, "/api/guest/bookings", nil, http.Header{"X-Guest-Token": {token}}, &bookings)
+	if status != http.StatusOK || bookings.Email != "gwen@example.com" || len(bookings.Bus.Upcoming) != 1 {
+		t.Fatalf("guest bookings: status %d, %+v", status, bookings)
+	}
+}
+
+func TestGuestTokenScope(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	trip := createTestTrip(t, newRepositories(db), "Scope Coach", 4)
+	own := bookTestSeat(t, db, r, trip, 1, "gwen@example.com")
+	other := bookTestSeat(t, db, r, trip, 2, "other@example.com")
+
+	token, err := issueGuestToken("gwen@example.com")
+	if err != nil {
+		t.Fatalf("issue guest token: %v", err)
+	}
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "gwen@example.com",
+		"typ": "guest",
+		"iat": time.Now().Add(-2 * guestLinkTTL).Unix(),
+		"exp": time.Now().Add(-guestLinkTTL).Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatalf("sign expired token: %v", err)
+	}
+	session := signIn(t, db, "gwen@example.com", roleCustomer).Get("Authorization")
+
+	guest := func(token string) http.Header { return http.Header{"X-Guest-Token": {token}} }
+	cancel := func(id uint) string { return fmt.Sprintf("/api/guest/bus/%d/bookings/%d/cancel", trip.ID, id) }
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		status int
+	}{
+		{"valid link", http.MethodGet, "/api/guest/bookings", guest(token), http.StatusOK},
+		{"expired link", http.MethodGet, "/api/guest/bookings", guest(expired), http.StatusUnauthorized},
+		{"session token as link", http.MethodGet, "/api/guest/bookings", guest(strings.TrimPrefix(session, "Bearer ")), http.StatusUnauthorized},
+		{"link as session token", http.MethodGet, "/api/me/bookings", http.Header{"Authorization": {"Bearer " + token}}, http.StatusUnauthorized},
+		{"another email's booking", http.MethodPost, cancel(other), guest(token), http.StatusForbidden},
+		{"own booking", http.MethodPost, cancel(own), guest(token), http.StatusOK},
+	} {
+		if status := doJSON(t, r, tc.method, tc.path, nil, tc.header, nil); status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+	}
+}
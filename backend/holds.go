@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type HoldRequest struct {
+	HoldToken       string `json:"holdToken"`
+	Seats           []int  `json:"seats"`
+	BoardingStopID  uint   `json:"boardingStopId"`  // first stop when omitted
+	AlightingStopID uint   `json:"alightingStopId"` // last stop when omitted
+}
+
+// holdDuration returns how long a seat hold lasts, configurable with SEAT_HOLD_MINUTES
+func holdDuration() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("SEAT_HOLD_MINUTES")); err == nil && v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return 10 * time.Minute
+}
+
+// seatRefusal explains why the holder of token cannot book a seat segment, or returns nil
+// when they can. A token confirms only its own live hold; without one, free seats and
+// holds that expired before the sweeper released them can be booked.
+func seatRefusal(seat BusSeat, token string) error {
+	live := seat.SeatStatus == "held" && seat.HoldExpiresAt != nil && seat.HoldExpiresAt.After(time.Now())
+	switch {
+	case seat.SeatStatus == "booked":
+		return &RuleError{Message: fmt.Sprintf("Seat %d already booked", seat.SeatNumber), Conflict: true}
+	case live && seat.HoldToken != token:
+		return &RuleError{Message: fmt.Sprintf("Seat %d is held by another customer", seat.SeatNumber), Conflict: true}
+	case token != "" && !live:
+		return &RuleError{Message: fmt.Sprintf("Hold on seat %d has expired or is not yours", seat.SeatNumber), Conflict: true}
+	}
+	return nil
+}
+
+// uniqueSeats drops repeated seat numbers so row counts can be compared
+func uniqueSeats(seats []int) []int {
+	seen := make(map[int]bool, len(seats))
+	var unique []int
+	for _, s := range seats {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// Handler to hold seats for a customer while they fill in the booking form
+func holdSeatsHandler(buses BusRepository, bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req HoldRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if len(req.Seats) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No seat selected"})
+			return
+		}
+		if req.HoldToken == "" {
+			req.HoldToken = uuid.New().String()
+		}
+		req.Seats = uniqueSeats(req.Seats)
+
+		tripID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+		trip, err := buses.GetTrip(tripID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+		journey, err := tripJourney(trip, req.BoardingStopID, req.AlightingStopID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		expiresAt := time.Now().Add(holdDuration())
+		if err := bookings.HoldSeats(trip.ID, journey.From, journey.To, req.Seats, req.HoldToken, expiresAt); err != nil {
+			respondBookingError(c, err, "Failed to hold seats")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"holdToken": req.HoldToken,
+			"seats":     req.Seats,
+			"journey":   journey,
+			"expiresAt": expiresAt,
+		})
+	}
+}
+
+// Handler to release seats held by a token before they expire
+func releaseSeatsHandler(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req HoldRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.HoldToken == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		tripID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+
+		// Release every seat of the token unless specific seats are given
+		released, err := bookings.ReleaseSeats(tripID, req.HoldToken, req.Seats)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release seats"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"released": released})
+	}
+}
+
+// startHoldSweeper periodically releases expired seat holds
+func startHoldSweeper(bookings BookingRepository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		released, err := bookings.ReleaseExpiredHolds()
+		if err != nil {
+			log.Printf("Failed to release expired holds: %v", err)
+			continue
+		}
+		if released > 0 {
+			log.Printf("Released %d expired seat hold(s)", released)
+		}
+	}
+}
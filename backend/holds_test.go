@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBookingHeldSeats(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	trip := createTestTrip(t, newRepositories(db), "Hold Coach", 5)
+
+	hold := func(seat int) string {
+		var resp struct {
+			HoldToken string `json:"holdToken"`
+		}
+		path := fmt.Sprintf("/api/bus/%d/seats/hold", trip.ID)
+		if status := doJSON(t, r, http.MethodPost, path, HoldRequest{Seats: []int{seat}}, nil, &resp); status != http.StatusOK {
+			t.Fatalf("hold seat %d: status %d", seat, status)
+		}
+		return resp.HoldToken
+	}
+	first, second, lapsed := hold(1), hold(2), hold(3)
+
+	// The sweeper has not run yet, so the lapsed hold is still in place
+	db.Model(&BusSeat{}).Where("trip_id = ? AND seat_number = ?", trip.ID, 3).
+		Update("hold_expires_at", time.Now().Add(-time.Minute))
+
+	for _, tc := range []struct {
+		name   string
+		seat   int
+		token  string
+		status int
+		error  string
+	}{
+		{"confirm own hold", 1, first, http.StatusOK, ""},
+		{"foreign token on a live hold", 2, first, http.StatusConflict, "Seat 2 is held by another customer"},
+		{"no token on a live hold", 2, "", http.StatusConflict, "Seat 2 is held by another customer"},
+		{"token on a free seat", 4, second, http.StatusConflict, "Hold on seat 4 has expired or is not yours"},
+		{"token of an expired hold", 3, lapsed, http.StatusConflict, "Hold on seat 3 has expired or is not yours"},
+		{"no token on an expired hold", 3, "", http.StatusOK, ""},
+		{"token on a booked seat", 1, first, http.StatusConflict, "Seat 1 already booked"},
+	} {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+			FirstName:     "Hana",
+			LastName:      "Holder",
+			Email:         "hana@example.com",
+			SelectedSeats: []int{tc.seat},
+			HoldToken:     tc.token,
+		}, nil, &resp)
+		if status != tc.status || resp.Error != tc.error {
+			t.Errorf("%s: status %d %q, want %d %q", tc.name, status, resp.Error, tc.status, tc.error)
+		}
+	}
+
+	// Confirming a hold clears it from the seat
+	var seat BusSeat
+	if err := db.Where("trip_id = ? AND seat_number = ?", trip.ID, 1).First(&seat).Error; err != nil {
+		t.Fatalf("load seat: %v", err)
+	}
+	if seat.SeatStatus != "booked" || seat.HoldToken != "" || seat.HoldExpiresAt != nil {
+		t.Errorf("confirmed seat is %s with hold %q until %v", seat.SeatStatus, seat.HoldToken, seat.HoldExpiresAt)
+	}
+}
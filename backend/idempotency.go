@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idempotencyLease is how long a request may hold its key before a retry can take it over,
+// so a request that died mid-flight does not block its key for the whole window
+const idempotencyLease = 2 * time.Minute
+
+// IdempotencyRecord stores the outcome of a request made with an Idempotency-Key
+type IdempotencyRecord struct {
+	IdempotencyKey string `gorm:"primaryKey;size:191"` // hash of route, caller and client key
+	RequestHash    string `gorm:"size:64"`
+	ClaimToken     string `gorm:"size:36"` // identifies the request holding the key
+	LockedUntil    time.Time
+	Completed      bool
+	StatusCode     int
+	ResponseBody   string `gorm:"type:text"`
+	CreatedAt      time.Time
+	ExpiresAt      time.Time `gorm:"index"`
+}
+
+// idempotencyWindow returns how long keys are remembered, configurable with IDEMPOTENCY_TTL_HOURS
+func idempotencyWindow() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL_HOURS")); err == nil && v > 0 {
+		return time.Duration(v) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// responseRecorder keeps a copy of the response body written by a handler
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyCaller names who sent the request, so two callers never share a key: the
+// signed-in user, the guest link, or for guest checkout the email being booked for
+func idempotencyCaller(c *gin.Context, body []byte) string {
+	if user, ok := currentUser(c); ok {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	if email, ok := currentGuestEmail(c); ok {
+		return "guest:" + strings.ToLower(email)
+	}
+	var guest struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &guest) == nil && guest.Email != "" {
+		return "guest:" + strings.ToLower(strings.TrimSpace(guest.Email))
+	}
+	return "anonymous"
+}
+
+// IdempotencyMiddleware replays the stored response when a request is retried with the
+// same Idempotency-Key, so a retry never creates a second booking
+func IdempotencyMiddleware(conn *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 128 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(c.Request.Method+" "+c.Request.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+		// Keys are scoped to the route and the caller so one key cannot collide across
+		// endpoints or customers; hashing keeps the scoped key within the column size
+		scope := sha256.Sum256([]byte(c.FullPath() + "|" + idempotencyCaller(c, body) + "|" + key))
+		scopedKey := hex.EncodeToString(scope[:])
+
+		now := time.Now()
+		record := IdempotencyRecord{
+			IdempotencyKey: scopedKey,
+			RequestHash:    hash,
+			ClaimToken:     uuid.New().String(),
+			LockedUntil:    now.Add(idempotencyLease),
+			CreatedAt:      now,
+			ExpiresAt:      now.Add(idempotencyWindow()),
+		}
+
+		// A key past its window can be reused
+		conn.Where("idempotency_key = ? AND expires_at < ?", scopedKey, now).Delete(&IdempotencyRecord{})
+
+		// Claim the key; if someone already has it, replay or reject instead
+		claim := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if claim.Error != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to store idempotency key"})
+			return
+		}
+		if claim.RowsAffected == 0 {
+			// The same request whose holder let its lease run out may take the key over
+			claim = conn.Model(&IdempotencyRecord{}).
+				Where("idempotency_key = ? AND request_hash = ? AND completed = ?", scopedKey, hash, false).
+				Where("locked_until IS NULL OR locked_until < ?", now).
+				Updates(map[string]interface{}{"claim_token": record.ClaimToken, "locked_until": record.LockedUntil})
+			if claim.Error != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to store idempotency key"})
+				return
+			}
+		}
+		if claim.RowsAffected == 0 {
+			var existing IdempotencyRecord
+			if err := conn.First(&existing, "idempotency_key = ?", scopedKey).Error; err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load idempotency key"})
+				return
+			}
+
+			switch {
+			case existing.RequestHash != hash:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case !existing.Completed:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.StatusCode, "application/json; charset=utf-8", []byte(existing.ResponseBody))
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Only the holder of the claim may settle the key; a request that outlived its
+		// lease has been taken over by a retry
+		owned := conn.Model(&IdempotencyRecord{}).Where("idempotency_key = ? AND claim_token = ?", scopedKey, record.ClaimToken)
+
+		// Server errors roll the booking back, so let the client retry with the same key
+		if recorder.Status() >= http.StatusInternalServerError {
+			owned.Delete(&IdempotencyRecord{})
+			return
+		}
+		if err := owned.Updates(map[string]interface{}{
+			"completed":     true,
+			"status_code":   recorder.Status(),
+			"response_body": recorder.body.String(),
+		}).Error; err != nil {
+			log.Printf("Failed to store idempotent response for %s: %v", scopedKey, err)
+		}
+	}
+}
+
+// purgeIdempotencyKeys removes keys that are out of their window
+func purgeIdempotencyKeys(conn *gorm.DB) (int64, error) {
+	result := conn.Where("expires_at < ?", time.Now()).Delete(&IdempotencyRecord{})
+	return result.RowsAffected, result.Error
+}
+
+// startIdempotencyPurger periodically removes expired idempotency keys
+func startIdempotencyPurger(conn *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := purgeIdempotencyKeys(conn); err != nil {
+			log.Printf("Failed to purge idempotency keys: %v", err)
+		}
+	}
+}
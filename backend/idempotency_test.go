@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// newIdempotentRouter serves a handler behind the idempotency middleware and counts its runs
+func newIdempotentRouter(conn *gorm.DB, runs *int) *gin.Engine {
+	r := gin.New()
+	r.Use(AuthMiddleware(newRepositories(conn).Users))
+	r.POST("/orders", IdempotencyMiddleware(conn), func(c *gin.Context) {
+		*runs++
+		c.JSON(http.StatusOK, gin.H{"run": *runs})
+	})
+	return r
+}
+
+func TestIdempotencyKeysAreScopedByCaller(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	var runs int
+	r := newIdempotentRouter(db, &runs)
+	key := http.Header{"Idempotency-Key": {"order-1"}}
+
+	for _, caller := range []http.Header{
+		signIn(t, db, "first@example.com", roleCustomer),
+		signIn(t, db, "second@example.com", roleCustomer),
+	} {
+		header := caller.Clone()
+		header.Set("Idempotency-Key", key.Get("Idempotency-Key"))
+		if status := doJSON(t, r, http.MethodPost, "/orders", gin.H{"email": "first@example.com"}, header, nil); status != http.StatusOK {
+			t.Fatalf("status %d, want %d", status, http.StatusOK)
+		}
+	}
+	// Guests checking out for different emails do not share keys either
+	for _, email := range []string{"guest1@example.com", "guest2@example.com"} {
+		if status := doJSON(t, r, http.MethodPost, "/orders", gin.H{"email": email}, key, nil); status != http.StatusOK {
+			t.Fatalf("guest %s: status %d, want %d", email, status, http.StatusOK)
+		}
+	}
+	if runs != 4 {
+		t.Fatalf("handler ran %d times, want 4", runs)
+	}
+
+	// The same caller retrying gets the stored response
+	if status := doJSON(t, r, http.MethodPost, "/orders", gin.H{"email": "guest1@example.com"}, key, nil); status != http.StatusOK || runs != 4 {
+		t.Fatalf("retry: status %d, handler ran %d times, want a replay", status, runs)
+	}
+}
+
+func TestIdempotencyLeaseExpires(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	var runs int
+	r := newIdempotentRouter(db, &runs)
+	key := http.Header{"Idempotency-Key": {"order-2"}}
+	body := gin.H{"email": "slow@example.com"}
+
+	if status := doJSON(t, r, http.MethodPost, "/orders", body, key, nil); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+
+	// Pretend the request is still running
+	db.Model(&IdempotencyRecord{}).Where("1 = 1").Updates(map[string]interface{}{
+		"completed": false, "locked_until": time.Now().Add(time.Minute),
+	})
+	if status := doJSON(t, r, http.MethodPost, "/orders", body, key, nil); status != http.StatusConflict {
+		t.Fatalf("in progress: status %d, want %d", status, http.StatusConflict)
+	}
+
+	// Once its lease is over, the retry takes the key and runs
+	db.Model(&IdempotencyRecord{}).Where("1 = 1").Update("locked_until", time.Now().Add(-time.Second))
+	if status := doJSON(t, r, http.MethodPost, "/orders", body, key, nil); status != http.StatusOK || runs != 2 {
+		t.Fatalf("after lease: status %d, handler ran %d times, want 2", status, runs)
+	}
+
+	var record IdempotencyRecord
+	if err := db.First(&record).Error; err != nil || !record.Completed {
+		t.Fatalf("record after takeover: %+v, %v", record, err)
+	}
+}
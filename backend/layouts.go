@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SeatLayout is a seating plan shared by the vehicles built to it. Seats sit on a grid
+// of decks, rows and columns; aisle columns and blocked cells (doors, stairs, toilets)
+// hold no seat.
+type SeatLayout struct {
+	gorm.Model
+	Name    string       `json:"name" gorm:"uniqueIndex;size:100"`
+	Decks   int          `json:"decks"`
+	Rows    int          `json:"rows"`
+	Columns int          `json:"columns"`
+	Aisles  string       `json:"aisles"` // comma-separated aisle column numbers, e.g. "3"
+	Seats   []LayoutSeat `json:"seats"`
+}
+
+// LayoutSeat is a seat of a layout. Rows and columns count from 1 at the front left.
+type LayoutSeat struct {
+	ID             uint   `json:"-" gorm:"primaryKey"`
+	SeatLayoutID   uint   `json:"-" gorm:"uniqueIndex:idx_layout_seat"`
+	SeatNumber     int    `json:"seatNumber" gorm:"uniqueIndex:idx_layout_seat"`
+	Deck           int    `json:"deck"`
+	Row            int    `json:"row"`
+	Column         int    `json:"column"`
+	Position       string `json:"position"` // window, aisle or middle
+	Class          string `json:"class"`    // standard unless the layout says otherwise
+	Accessible     bool   `json:"accessible"`
+	DriverAdjacent bool   `json:"driverAdjacent"`
+}
+
+// LayoutCell addresses a cell of the layout grid
+type LayoutCell struct {
+	Deck   int `json:"deck"`
+	Row    int `json:"row"`
+	Column int `json:"column"`
+}
+
+// SeatOverride changes the attributes of the seat in a cell
+type SeatOverride struct {
+	LayoutCell
+	Class          string `json:"class"`
+	Accessible     *bool  `json:"accessible"`
+	DriverAdjacent *bool  `json:"driverAdjacent"`
+}
+
+// SeatLayoutRequest is the body of POST /api/seat-layouts
+type SeatLayoutRequest struct {
+	Name       string         `json:"name"`
+	Decks      int            `json:"decks"`
+	Rows       int            `json:"rows"`
+	Columns    int            `json:"columns"`
+	Aisles     []int          `json:"aisles"`
+	DriverSide string         `json:"driverSide"` // left (the default) or right
+	Blocked    []LayoutCell   `json:"blocked"`
+	Seats      []SeatOverride `json:"seats"`
+}
+
+const defaultSeatClass = "standard"
+
+// parseAisles reads the aisle column numbers of a layout
+func parseAisles(list string) map[int]bool {
+	aisles := map[int]bool{}
+	for _, v := range strings.Split(list, ",") {
+		if column, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			aisles[column] = true
+		}
+	}
+	return aisles
+}
+
+// buildSeatLayout numbers the seats of a layout deck by deck, front to back and left to
+// right, skipping aisles and blocked cells. Seats by a window or an aisle are marked as
+// such, and the front seats of the lower deck on the driver's side of the aisle sit next
+// to the driver unless overridden.
+func buildSeatLayout(req SeatLayoutRequest) (SeatLayout, error) {
+	name := strings.TrimSpace(req.Name)
+	switch {
+	case name == "":
+		return SeatLayout{}, fmt.Errorf("name is required")
+	case req.Decks < 1 || req.Decks > 2:
+		return SeatLayout{}, fmt.Errorf("decks must be 1 or 2")
+	case req.Rows < 1 || req.Rows > 30:
+		return SeatLayout{}, fmt.Errorf("rows must be between 1 and 30")
+	case req.Columns < 1 || req.Columns > 8:
+		return SeatLayout{}, fmt.Errorf("columns must be between 1 and 8")
+	}
+	driverSide := strings.ToLower(strings.TrimSpace(req.DriverSide))
+	if driverSide != "" && driverSide != "left" && driverSide != "right" {
+		return SeatLayout{}, fmt.Errorf("driverSide must be left or right")
+	}
+	inGrid := func(cell LayoutCell) bool {
+		return cell.Deck >= 1 && cell.Deck <= req.Decks && cell.Row >= 1 && cell.Row <= req.Rows &&
+			cell.Column >= 1 && cell.Column <= req.Columns
+	}
+
+	aisles := map[int]bool{}
+	for _, column := range req.Aisles {
+		if column < 1 || column > req.Columns {
+			return SeatLayout{}, fmt.Errorf("aisle column %d is outside the layout", column)
+		}
+		aisles[column] = true
+	}
+	var aisleList []string
+	for column := 1; column <= req.Columns; column++ {
+		if aisles[column] {
+			aisleList = append(aisleList, strconv.Itoa(column))
+		}
+	}
+
+	blocked := map[LayoutCell]bool{}
+	for _, cell := range req.Blocked {
+		if !inGrid(cell) {
+			return SeatLayout{}, fmt.Errorf("blocked cell %d/%d/%d is outside the layout", cell.Deck, cell.Row, cell.Column)
+		}
+		blocked[cell] = true
+	}
+
+	// Window seats are in the outermost seat columns
+	first, last := 0, 0
+	for column := 1; column <= req.Columns; column++ {
+		if aisles[column] {
+			continue
+		}
+		if first == 0 {
+			first = column
+		}
+		last = column
+	}
+
+	// The driver sits in front of the seats between their side and the first aisle, or of
+	// the window seat on their side when no aisle crosses the layout
+	step, driverColumn := 1, first
+	if driverSide == "right" {
+		step, driverColumn = -1, last
+	}
+	driverColumns := map[int]bool{}
+	for column := driverColumn; column >= first && column <= last && !aisles[column]; column += step {
+		driverColumns[column] = true
+	}
+	if len(driverColumns) == last-first+1 {
+		driverColumns = map[int]bool{driverColumn: true}
+	}
+
+	layout := SeatLayout{Name: name, Decks: req.Decks, Rows: req.Rows, Columns: req.Columns, Aisles: strings.Join(aisleList, ",")}
+	cells := map[LayoutCell]int{}
+	for deck := 1; deck <= req.Decks; deck++ {
+		for row := 1; row <= req.Rows; row++ {
+			for column := 1; column <= req.Columns; column++ {
+				cell := LayoutCell{Deck: deck, Row: row, Column: column}
+				if aisles[column] || blocked[cell] {
+					continue
+				}
+				position := "middle"
+				if column == first || column == last {
+					position = "window"
+				} else if aisles[column-1] || aisles[column+1] {
+					position = "aisle"
+				}
+				cells[cell] = len(layout.Seats)
+				layout.Seats = append(layout.Seats, LayoutSeat{
+					SeatNumber:     len(layout.Seats) + 1,
+					Deck:           deck,
+					Row:            row,
+					Column:         column,
+					Position:       position,
+					Class:          defaultSeatClass,
+					DriverAdjacent: deck == 1 && row == 1 && driverColumns[column],
+				})
+			}
+		}
+	}
+	if len(layout.Seats) == 0 {
+		return SeatLayout{}, fmt.Errorf("the layout has no seats")
+	}
+
+	for _, o := range req.Seats {
+		i, ok := cells[o.LayoutCell]
+		if !ok {
+			return SeatLayout{}, fmt.Errorf("there is no seat at %d/%d/%d", o.Deck, o.Row, o.Column)
+		}
+		seat := &layout.Seats[i]
+		if class := strings.ToLower(strings.TrimSpace(o.Class)); class != "" {
+			seat.Class = class
+		}
+		if o.Accessible != nil {
+			seat.Accessible = *o.Accessible
+		}
+		if o.DriverAdjacent != nil {
+			seat.DriverAdjacent = *o.DriverAdjacent
+		}
+	}
+	return layout, nil
+}
+
+// defaultSeatLayout is the layout of vehicles without one: rows of two seats either side
+// of an aisle, all standard
+func defaultSeatLayout(capacity int) SeatLayout {
+	rows := (capacity + 3) / 4
+	if rows < 1 {
+		rows = 1
+	}
+	layout, _ := buildSeatLayout(SeatLayoutRequest{Name: "default", Decks: 1, Rows: rows, Columns: 5, Aisles: []int{3}})
+	if len(layout.Seats) > capacity {
+		layout.Seats = layout.Seats[:capacity]
+	}
+	return layout
+}
+
+// SeatMapSeat is a seat of a trip as the seat map draws it
+type SeatMapSeat struct {
+	LayoutSeat
+	Status string `json:"status"`
+}
+
+// SeatGrid is the shape of a layout without its seats
+type SeatGrid struct {
+	Name    string `json:"name"`
+	Decks   int    `json:"decks"`
+	Rows    int    `json:"rows"`
+	Columns int    `json:"columns"`
+	Aisles  []int  `json:"aisles"`
+}
+
+// seatMap places the seats of a trip on its layout. Seats the layout does not know of
+// are standard seats without a position.
+func seatMap(layout SeatLayout, seats []SeatAvailability) (SeatGrid, []SeatMapSeat) {
+	grid := SeatGrid{Name: layout.Name, Decks: layout.Decks, Rows: layout.Rows, Columns: layout.Columns, Aisles: []int{}}
+	aisles := parseAisles(layout.Aisles)
+	for column := 1; column <= layout.Columns; column++ {
+		if aisles[column] {
+			grid.Aisles = append(grid.Aisles, column)
+		}
+	}
+
+	bySeat := make(map[int]LayoutSeat, len(layout.Seats))
+	for _, seat := range layout.Seats {
+		bySeat[seat.SeatNumber] = seat
+	}
+	result := make([]SeatMapSeat, 0, len(seats))
+	for _, seat := range seats {
+		placed, ok := bySeat[seat.SeatNumber]
+		if !ok {
+			placed = LayoutSeat{SeatNumber: seat.SeatNumber, Class: defaultSeatClass}
+		}
+		result = append(result, SeatMapSeat{LayoutSeat: placed, Status: seat.Status})
+	}
+	return grid, result
+}
+
+// Handler to list seat layout templates
+func getAllSeatLayouts(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		layouts, err := buses.ListSeatLayouts()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seat layouts"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"layouts": layouts})
+	}
+}
+
+// Handler to create a seat layout template
+func createSeatLayout(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SeatLayoutRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		layout, err := buildSeatLayout(req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := buses.CreateSeatLayout(&layout); err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				c.JSON(http.StatusConflict, gin.H{"error": "A seat layout with this name already exists"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create seat layout"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"layout": layout})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCreateSeatLayoutDuplicateName(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	admin := signIn(t, db, "admin@example.com", roleAdmin)
+
+	req := SeatLayoutRequest{Name: "Coach 2+2", Decks: 1, Rows: 2, Columns: 5, Aisles: []int{3}}
+	if status := doJSON(t, r, http.MethodPost, "/api/seat-layouts", req, admin, nil); status != http.StatusOK {
+		t.Fatalf("first layout: status %d, want 200", status)
+	}
+	if status := doJSON(t, r, http.MethodPost, "/api/seat-layouts", req, admin, nil); status != http.StatusConflict {
+		t.Fatalf("duplicate name: status %d, want 409", status)
+	}
+
+	// Any other failure is a server error, not a conflict
+	if err := db.Migrator().DropTable(&LayoutSeat{}); err != nil {
+		t.Fatalf("drop layout seats: %v", err)
+	}
+	req.Name = "Coach 2+1"
+	if status := doJSON(t, r, http.MethodPost, "/api/seat-layouts", req, admin, nil); status != http.StatusInternalServerError {
+		t.Fatalf("layout seats table dropped: status %d, want 500", status)
+	}
+}
+
+func TestSeatLayoutDriverAdjacentSeats(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		req  SeatLayoutRequest
+		want []int // seat numbers next to the driver
+	}{
+		{"left-hand drive 2+2", SeatLayoutRequest{Name: "2+2", Decks: 1, Rows: 2, Columns: 5, Aisles: []int{3}}, []int{1, 2}},
+		{"right-hand drive 2+1", SeatLayoutRequest{Name: "2+1", Decks: 1, Rows: 2, Columns: 4, Aisles: []int{3}, DriverSide: "Right"}, []int{3}},
+		{"no aisle", SeatLayoutRequest{Name: "bench", Decks: 1, Rows: 2, Columns: 3, DriverSide: "right"}, []int{3}},
+		{"double decker", SeatLayoutRequest{Name: "deck", Decks: 2, Rows: 1, Columns: 5, Aisles: []int{3}}, []int{1, 2}},
+	} {
+		layout, err := buildSeatLayout(tc.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var got []int
+		for _, seat := range layout.Seats {
+			if seat.DriverAdjacent {
+				got = append(got, seat.SeatNumber)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: seats %v next to the driver, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if _, err := buildSeatLayout(SeatLayoutRequest{Name: "odd", Decks: 1, Rows: 1, Columns: 2, DriverSide: "middle"}); err == nil {
+		t.Error("driver side middle: no error")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const deadlockRetries = 3
+
+// MySQL error codes for transactions aborted because of lock contention
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+)
+
+// Postgres SQLSTATE codes for the same conditions. Postgres also aborts a transaction
+// with a serialization failure when it cannot order it against a concurrent one.
+const (
+	pgErrSerializationFailure = "40001"
+	pgErrDeadlockDetected     = "40P01"
+	pgErrLockNotAvailable     = "55P03"
+)
+
+// isDeadlock reports whether err means the transaction was rolled back by the
+// database to resolve a lock conflict and can safely be retried
+func isDeadlock(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgErrSerializationFailure, pgErrDeadlockDetected, pgErrLockNotAvailable:
+			return true
+		}
+	}
+	return false
+}
+
+// withDeadlockRetry runs fn, running it again with a short backoff when it fails on a deadlock
+func withDeadlockRetry(fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= deadlockRetries; attempt++ {
+		err = fn()
+		if err == nil || !isDeadlock(err) {
+			return err
+		}
+		log.Printf("Deadlock detected (attempt %d/%d), retrying", attempt, deadlockRetries)
+		time.Sleep(time.Duration(attempt*50) * time.Millisecond)
+	}
+	return err
+}
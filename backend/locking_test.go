@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsDeadlock(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"mysql deadlock", &mysql.MySQLError{Number: mysqlErrDeadlock}, true},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: mysqlErrLockWaitTimeout}, true},
+		{"mysql duplicate key", &mysql.MySQLError{Number: 1062}, false},
+		{"postgres deadlock", &pgconn.PgError{Code: pgErrDeadlockDetected}, true},
+		{"postgres serialization failure", &pgconn.PgError{Code: pgErrSerializationFailure}, true},
+		{"postgres lock not available", &pgconn.PgError{Code: pgErrLockNotAvailable}, true},
+		{"postgres unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"wrapped deadlock", fmt.Errorf("take seats: %w", &pgconn.PgError{Code: pgErrDeadlockDetected}), true},
+		{"other error", errors.New("connection refused"), false},
+		{"no error", nil, false},
+	} {
+		if got := isDeadlock(tc.err); got != tc.want {
+			t.Errorf("%s: isDeadlock = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWithDeadlockRetry(t *testing.T) {
+	deadlock := fmt.Errorf("book seats: %w", &mysql.MySQLError{Number: mysqlErrDeadlock})
+	other := errors.New("disk full")
+	for _, tc := range []struct {
+		name  string
+		fails []error // errors of the first attempts; later attempts succeed
+		calls int
+		err   error
+	}{
+		{"succeeds at once", nil, 1, nil},
+		{"succeeds after deadlocks", []error{deadlock, deadlock}, 3, nil},
+		{"gives up after the last retry", []error{deadlock, deadlock, deadlock, deadlock}, deadlockRetries, deadlock},
+		{"does not retry other errors", []error{other}, 1, other},
+	} {
+		calls := 0
+		err := withDeadlockRetry(func() error {
+			calls++
+			if calls <= len(tc.fails) {
+				return tc.fails[calls-1]
+			}
+			return nil
+		})
+		if calls != tc.calls || !errors.Is(err, tc.err) {
+			t.Errorf("%s: %d calls, error %v, want %d calls, error %v", tc.name, calls, err, tc.calls, tc.err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email ready to be delivered by a Mailer
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a Message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer delivers ticket emails to customers
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTPMailer sends mail through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := m.Host + ":" + m.Port
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, buildMessage(m.From, msg))
+}
+
+// FileMailer writes each message as an .eml file into a directory
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.ReplaceAll(msg.To, "@", "_at_"))
+	return os.WriteFile(filepath.Join(m.Dir, name), buildMessage(m.From, msg), 0o644)
+}
+
+// MemoryMailer keeps sent messages in memory, useful for tests
+type MemoryMailer struct {
+	mu   sync.Mutex
+	Sent []Message
+}
+
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sent = append(m.Sent, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.Sent...)
+}
+
+// newMailerFromEnv picks the mailer from MAIL_DRIVER (smtp, file or memory). It has no
+// default, so a deployment cannot silently keep its ticket emails in memory.
+func newMailerFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "tickets@beetours.local"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "25"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASS"),
+			From:     from,
+		}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "tmp/mail"
+		}
+		return &FileMailer{Dir: dir, From: from}, nil
+	case "memory":
+		log.Println("MAIL_DRIVER=memory, outgoing mail is kept in memory and never delivered")
+		return &MemoryMailer{}, nil
+	case "":
+		return nil, fmt.Errorf("MAIL_DRIVER not set, use smtp, file or memory")
+	default:
+		return nil, fmt.Errorf("unsupported MAIL_DRIVER %q", driver)
+	}
+}
+
+// buildMessage renders an RFC 5322 message, as multipart/mixed when it has attachments
+func buildMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	body := strings.ReplaceAll(msg.Body, "\n", "\r\n")
+	if len(msg.Attachments) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(body)
+		return []byte(b.String())
+	}
+
+	boundary := fmt.Sprintf("bookings-%d", time.Now().UnixNano())
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(body + "\r\n")
+
+	for _, a := range msg.Attachments {
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s; name=%q\r\n", a.ContentType, a.Filename)
+		b.WriteString("Content-Transfer-Encoding: base64\r\n")
+		fmt.Fprintf(&b, "Content-Disposition: attachment; filename=%q\r\n\r\n", a.Filename)
+
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			b.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		b.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return []byte(b.String())
+}
+
+// busTicketMessage builds the confirmation email for booked bus seats
+func busTicketMessage(trip Trip, journey Journey, tickets []BusTicket, quote Quote) Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s %s,\n\n", tickets[0].FirstName, tickets[0].LastName)
+	fmt.Fprintf(&b, "Your booking on %s from %s to %s is confirmed.\n", trip.Vehicle.Name, journey.Boarding.Name, journey.Alighting.Name)
+	fmt.Fprintf(&b, "Departure from %s: %s\n\n", trip.Route.Origin, busDepartureText(trip))
+	for _, t := range tickets {
+		fmt.Fprintf(&b, "Seat %d - ticket %s\n", t.SeatNumber, t.ID)
+	}
+	if quote.Total > 0 {
+		fmt.Fprintf(&b, "\nTotal paid: %s (tax %s)\n", formatMoney(quote.Total, quote.Currency), formatMoney(quote.Tax, quote.Currency))
+	}
+	b.WriteString("\nPlease show your ticket ID when boarding.\n")
+
+	return Message{
+		To:      tickets[0].Email,
+		Subject: fmt.Sprintf("Your %s tickets", trip.Vehicle.Name),
+		Body:    b.String(),
+	}
+}
+
+// conferenceTicketMessage builds the confirmation email for a conference booking
+func conferenceTicketMessage(conference Conference, booking ConferenceBooking) Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s %s,\n\n", booking.FirstName, booking.LastName)
+	fmt.Fprintf(&b, "You have booked %d ticket(s) for %s.\n\n", booking.Tickets, conference.Title)
+	fmt.Fprintf(&b, "Location: %s\n", conference.Location)
+	fmt.Fprintf(&b, "Dates: %s\n", conferenceDatesText(conference))
+	fmt.Fprintf(&b, "Booking reference: %d\n", booking.ID)
+	if booking.Total > 0 {
+		fmt.Fprintf(&b, "Total paid: %s (tax %s)\n", formatMoney(booking.Total, booking.Currency), formatMoney(booking.Tax, booking.Currency))
+	}
+
+	return Message{
+		To:      booking.Email,
+		Subject: fmt.Sprintf("Your tickets for %s", conference.Title),
+		Body:    b.String(),
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer accepts mail on a local port and passes the DATA of every message on
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fake.smtp ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake.smtp")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestBusTicketEmailOverSMTP(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	addr, messages := fakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	mailer = &SMTPMailer{Host: host, Port: port, From: "tickets@example.com"}
+
+	r := newTestRouter(db)
+	trip := createTestTrip(t, newRepositories(db), "Mail Coach", 6)
+
+	var booked struct {
+		SeatNumbers []int    `json:"seatNumbers"`
+		TicketIDs   []string `json:"ticketIDs"`
+	}
+	status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+		FirstName: "Mia", LastName: "Mailer", Email: "mia@example.com", SelectedSeats: []int{5, 3},
+	}, nil, &booked)
+	if status != http.StatusOK || len(booked.TicketIDs) != 2 {
+		t.Fatalf("book: status %d, tickets %v", status, booked.TicketIDs)
+	}
+
+	if processed := deliverOutbox(db); processed != 1 {
+		t.Fatalf("delivered %d outbox messages, want 1", processed)
+	}
+	var data string
+	select {
+	case data = <-messages:
+	default:
+		t.Fatal("the SMTP server received no message")
+	}
+
+	if !strings.Contains(data, "To: mia@example.com") {
+		t.Errorf("message is not addressed to the customer:\n%s", data)
+	}
+	for i, id := range booked.TicketIDs {
+		if want := fmt.Sprintf("Seat %d - ticket %s", booked.SeatNumbers[i], id); !strings.Contains(data, want) {
+			t.Errorf("message does not contain %q", want)
+		}
+		if want := fmt.Sprintf(`filename="ticket-%s.pdf"`, id); !strings.Contains(data, want) {
+			t.Errorf("message has no attachment %q", want)
+		}
+	}
+}
+
+func TestMailerFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		driver string
+		ok     bool
+	}{
+		{"smtp", true},
+		{"file", true},
+		{"memory", true},
+		{"", false},
+		{"sendmail", false},
+	} {
+		t.Setenv("MAIL_DRIVER", tc.driver)
+		m, err := newMailerFromEnv()
+		if tc.ok && (err != nil || m == nil) {
+			t.Errorf("MAIL_DRIVER=%q: got error %v", tc.driver, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("MAIL_DRIVER=%q: got %T, want an error", tc.driver, m)
+		}
+	}
+}
@@ -1,20 +1,17 @@
 package main
 
 import (
-	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
@@ -27,97 +24,168 @@ func init() {
 }
 
 var (
-	waitgroup sync.WaitGroup
-	db        *gorm.DB
-	busName   = "Bee Tours"
+	mailer  Mailer
+	busName = "Bee Tours"
 )
 
-type Bus struct {
-	gorm.Model
-	Name           string `json:"name" validate:"required,min=3,max=50"`
-	Origin         string `json:"origin" validate:"required,min=3,max=50"`
-	Destination    string `json:"destination" validate:"required,min=3,max=50"`
-	Date           string `json:"tripDate" validate:"required,date"`
-	DepartureTime  string `json:"departureTime" validate:"required,time"`
-	ArrivalTime    string `json:"arrivalTime" validate:"required,time"`
-	TotalSeats     int    `json:"totalSeats" validate:"required,min=1"`
-	RemainingSeats int    `json:"remainingSeats" `
-}
-
 type BusSeat struct {
 	gorm.Model
-	BusID      uint   `json:"busId" validate:"required"`
-	SeatNumber int    `json:"seatNumber" validate:"required,min=1"`
-	SeatStatus string `json:"seatStatus" gorm:"default:available"`
-	BookingID  uint   `json:"bookingId"`
+	TripID        uint       `json:"tripId" validate:"required" gorm:"uniqueIndex:idx_bus_seat"`
+	SeatNumber    int        `json:"seatNumber" validate:"required,min=1" gorm:"uniqueIndex:idx_bus_seat"`
+	Segment       int        `json:"segment" gorm:"uniqueIndex:idx_bus_seat;not null;default:0"`
+	SeatStatus    string     `json:"seatStatus" gorm:"default:available"`
+	BookingID     uint       `json:"bookingId"`
+	HoldToken     string     `json:"-" gorm:"index"`
+	HoldExpiresAt *time.Time `json:"holdExpiresAt"`
 }
 
 type BookingRequest struct {
-	FirstName     string `json:"firstName"`
-	LastName      string `json:"lastName"`
-	Email         string `json:"email"`
-	SelectedSeats []int  `json:"selectedSeats"`
+	FirstName       string `json:"firstName"`
+	LastName        string `json:"lastName"`
+	Email           string `json:"email"`
+	SelectedSeats   []int  `json:"selectedSeats"`
+	HoldToken       string `json:"holdToken"`
+	BoardingStopID  uint   `json:"boardingStopId"`  // first stop when omitted
+	AlightingStopID uint   `json:"alightingStopId"` // last stop when omitted
 }
 
 type BusBooking struct {
 	gorm.Model
-	FirstName   string `json:"firstName" validate:"required,min=3,max=50"`
-	LastName    string `json:"lastName" validate:"required,min=3,max=50"`
-	Email       string `json:"email" validate:"required,email"`
-	SeatNumber  int    `json:"seatNumber" validate:"required,min=1"`
-	BusID       uint   `json:"busId" validate:"required"`
-	BusName     string `json:"busName" `
-	BookingDate string `json:"bookingDate"`
-	BookingTime string `json:"bookingTime"`
-	Status      string `json:"status" gorm:"default:confirmed"`
+	FirstName  string    `json:"firstName" validate:"required,min=3,max=50"`
+	LastName   string    `json:"lastName" validate:"required,min=3,max=50"`
+	Email      string    `json:"email" validate:"required,email"`
+	SeatNumber int       `json:"seatNumber" validate:"required,min=1"`
+	TripID     uint      `json:"tripId" validate:"required"`
+	BusName    string    `json:"busName" `
+	BookedAt   time.Time `json:"bookedAt"`
+	Status     string    `json:"status" gorm:"default:confirmed"`
+	UserID     *uint     `json:"userId" gorm:"index"`
+
+	BoardingStopID  uint   `json:"boardingStopId"`
+	BoardingStop    string `json:"boardingStop"`
+	AlightingStopID uint   `json:"alightingStopId"`
+	AlightingStop   string `json:"alightingStop"`
+
+	// Price of the seat for the journey, in minor units of Currency
+	SeatClass string `json:"seatClass"`
+	Currency  string `json:"currency" gorm:"size:3"`
+	Price     int64  `json:"price"`
+	Tax       int64  `json:"tax"`
+	Total     int64  `json:"total"`
 }
 
 type Conference struct {
 	gorm.Model
-	Title            string `json:"title" validate:"required,min=3,max=50"`
-	Description      string `json:"description" validate:"required,min=3,max=500"`
-	StartDate        string `json:"startDate" validate:"required,date"`
-	EndDate          string `json:"endDate" validate:"required,date"`
-	Location         string `json:"location" validate:"required,min=3,max=50"`
-	TotalTickets     int    `json:"totalTickets" validate:"required,min=1"`
-	RemainingTickets int    `json:"remainingTickets" `
+	Title            string       `json:"title" validate:"required,min=3,max=50"`
+	Description      string       `json:"description" validate:"required,min=3,max=500"`
+	TimeZone         string       `json:"timeZone" validate:"timezone"`
+	StartsAt         time.Time    `json:"startsAt" validate:"required"`
+	EndsAt           time.Time    `json:"endsAt" validate:"required,gtfield=StartsAt"`
+	Location         string       `json:"location" validate:"required,min=3,max=50"`
+	TotalTickets     int          `json:"totalTickets" validate:"required,min=1"`
+	RemainingTickets int          `json:"remainingTickets" `
+	Currency         string       `json:"currency" gorm:"size:3"`
+	TaxBasisPoints   int          `json:"taxBasisPoints" gorm:"not null;default:0"`
+	Tiers            []TicketTier `json:"tiers"`
+	Free             bool         `json:"free" gorm:"not null;default:false"` // bookable without tiers
 }
 
 type ConferenceBooking struct {
 	gorm.Model
-	FirstName      string `json:"firstName" validate:"required,min=3,max=50"`
-	LastName       string `json:"lastName" validate:"required,min=3,max=50"`
-	Email          string `json:"email" validate:"required,email"`
-	Tickets        int    `json:"tickets" validate:"required,min=1"`
-	ConferenceID   uint   `json:"conferenceId" validate:"required"`
-	ConferenceName string `json:"conferenceName" `
-	BookingDate    string `json:"bookingDate"`
-	BookingTime    string `json:"bookingTime"`
+	FirstName        string    `json:"firstName" validate:"required,min=3,max=50"`
+	LastName         string    `json:"lastName" validate:"required,min=3,max=50"`
+	Email            string    `json:"email" validate:"required,email"`
+	Tickets          int       `json:"tickets" validate:"required,min=1"`
+	ConferenceID     uint      `json:"conferenceId" validate:"required"`
+	ConferenceName   string    `json:"conferenceName" `
+	BookedAt         time.Time `json:"bookedAt"`
+	Status           string    `json:"status" gorm:"default:confirmed"`
+	CancelledTickets int       `json:"cancelledTickets"`
+	UserID           *uint     `json:"userId" gorm:"index"`
+
+	// Price of the tickets as booked, in minor units of Currency. Cancelling tickets
+	// does not change it.
+	Tier      string `json:"tier"`
+	Currency  string `json:"currency" gorm:"size:3"`
+	UnitPrice int64  `json:"unitPrice"`
+	Subtotal  int64  `json:"subtotal"`
+	Tax       int64  `json:"tax"`
+	Total     int64  `json:"total"`
+}
+
+type ConferenceCancellation struct {
+	gorm.Model
+	ConferenceBookingID uint   `json:"conferenceBookingId"`
+	ConferenceID        uint   `json:"conferenceId"`
+	Tickets             int    `json:"tickets"`
+	Reason              string `json:"reason"`
+}
+
+type CancelRequest struct {
+	Tickets int    `json:"tickets"`
+	Reason  string `json:"reason"`
+}
+
+type ConferenceTicket struct {
+	ID                  string `gorm:"primaryKey" json:"id"` // UUID
+	ConferenceBookingID uint   `json:"conferenceBookingId" gorm:"index"`
+	ConferenceID        uint   `json:"conferenceId"`
+	AttendeeName        string `json:"attendeeName"`
+	AttendeeEmail       string `json:"attendeeEmail"`
+	Revoked             bool   `json:"revoked"`
+	Version             int    `json:"version" gorm:"not null;default:0"` // bumped on reassignment to retire older tokens
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
 }
 
 type BusTicket struct {
-	ID           string `gorm:"primaryKey" json:"id"` // UUID
-	BusBookingID uint   `json:"busBookingId"`
-	BusID        uint   `json:"busId"`
-	SeatNumber   int    `json:"seatNumber"`
-	FirstName    string `json:"firstName"`
-	LastName     string `json:"lastName"`
-	Email        string `json:"email"`
-	Used         bool   `json:"used"`
+	ID           string     `gorm:"primaryKey" json:"id"` // UUID
+	BusBookingID uint       `json:"busBookingId"`
+	TripID       uint       `json:"tripId"`
+	SeatNumber   int        `json:"seatNumber"`
+	FirstName    string     `json:"firstName"`
+	LastName     string     `json:"lastName"`
+	Email        string     `json:"email"`
+	Used         bool       `json:"used"`
+	UsedAt       *time.Time `json:"usedAt"`
+	CheckedInBy  string     `json:"checkedInBy"`
+	Revoked      bool       `json:"revoked"`
 	CreatedAt    time.Time
 }
 
 func main() {
+	// `bookings migrate up|down|status` manages the schema without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(os.Args[2:]))
+	}
+
+	conn := initDB()
+	repos := newRepositories(conn)
+	initAuth(repos.Users)
+	initTicketSigning()
+
+	// Deliver queued ticket emails
+	go startOutboxWorker(conn, 5*time.Second)
+
+	// Release seat holds from abandoned checkouts
+	go startHoldSweeper(repos.Bookings, time.Minute)
+
+	// Forget idempotency keys once their window has passed
+	go startIdempotencyPurger(conn, time.Hour)
 
-	initDB()
-	defer waitgroup.Wait() // Wait for all goroutines to finish
+	// Check the denormalized inventory counters for drift
+	go startReconcileJob(repos.Bookings)
+
+	// Materialize trips from recurring schedules
+	go startTripGenerator(repos.Schedules)
 
 	// Create Gin router
 	r := gin.Default()
 	r.Use(CORSMiddleware())
+	r.Use(AuthMiddleware(repos.Users))
 
 	// Set up routes
-	setupRoutes(r)
+	setupRoutes(r, conn, repos)
 
 	log.Println("Server running on :8085")
 	if err := r.Run(":8085"); err != nil {
@@ -126,101 +194,129 @@ func main() {
 	}
 }
 
-// function to initialize the database connection MySQL database
-func initDB() {
-	user := os.Getenv("DB_USER")
-	pass := os.Getenv("DB_PASS")
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	name := os.Getenv("DB_NAME")
-	var err error
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", user, pass, host, port, name)
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// function to initialize the database connection, see openDatabase for the supported drivers
+func initDB() *gorm.DB {
+	conn, err := openDatabase()
 	if err != nil {
 		log.Fatal("Failed to connect to the database:", err)
 	}
 
 	fmt.Println("Connected to the database")
 
-	// Migrate the schema
-	err = db.AutoMigrate(&BusBooking{}, &Bus{}, &BusSeat{}, &Conference{}, &ConferenceBooking{}, &BusTicket{})
-	if err != nil {
-		log.Fatal("Failed to migrate the database:", err)
-	}
-	fmt.Println("Database migrated")
-
-	setupInitialBus()
-}
-
-// Function to set up the initial bus if it doesn't exist
-func setupInitialBus() {
-	var bus Bus
-	result := db.Where("name = ?", busName).First(&bus)
-	if result.Error != nil && errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		// Create a new bus
-		bus = Bus{
-			Name:           busName,
-			Origin:         "City A",
-			Destination:    "City B",
-			DepartureTime:  "2023-10-01 10:00:00",
-			ArrivalTime:    "2023-10-01 11:00:00",
-			TotalSeats:     50,
-			RemainingSeats: 50,
-		}
-		if err := db.Create(&bus).Error; err != nil {
-			log.Fatal("Failed to create bus:", err)
-		}
-
-		// Create seats for this bus
-		var seats []BusSeat
-		for i := 1; i <= bus.TotalSeats; i++ {
-			seats = append(seats, BusSeat{
-				BusID:      bus.ID,
-				SeatNumber: i,
-				SeatStatus: "available",
-			})
-		}
-
-		if err := db.Create(&seats).Error; err != nil {
-			log.Fatal("Failed to create seats:", err)
+	// Apply pending schema migrations unless they are run separately with `bookings migrate up`
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		if _, err := migrateUp(conn); err != nil {
+			log.Fatal("Failed to migrate the database:", err)
 		}
+		fmt.Println("Database migrated")
+	}
 
-		fmt.Println("A new bus with seats has been created")
+	// Outgoing ticket mail is configured alongside the database
+	mailer, err = newMailerFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure mail:", err)
 	}
+
+	setupInitialBus(conn)
+	return conn
 }
 
-// Function to set up the routes for the API endpoints that handle bus and conference bookings from the frontend
-func setupRoutes(r *gin.Engine) {
+// Function to set up the routes for the API endpoints that handle bus and conference bookings from the frontend.
+// The outbox and idempotency keys work on conn, everything else goes through repos.
+func setupRoutes(r *gin.Engine, conn *gorm.DB, repos Repositories) {
+	staff := RequireRole(staffRoles...)
+	managers := RequireRole(roleAdmin, roleOperator)
+	signedIn := RequireRole()
+
+	// Auth endpoints
+	r.POST("/api/auth/register", registerHandler(repos.Users))
+	r.POST("/api/auth/login", loginHandler(repos.Users))
+	r.GET("/api/auth/me", signedIn, meHandler)
+
+	// Customer portal endpoints
+	r.GET("/api/me/bookings", signedIn, getMyBookings(repos.Bookings))
+
+	// Guest endpoints, authorized by the magic link token
+	guest := GuestMiddleware()
+	r.POST("/api/guest/lookup", guestLookupHandler(repos.Bookings, conn))
+	r.GET("/api/guest/bookings", guest, getGuestBookings(repos.Bookings))
+	r.POST("/api/guest/bus/:id/bookings/:bookingId/cancel", guest, cancelBusBookingHandler(repos.Bookings))
+	r.POST("/api/guest/conference/:id/bookings/:bookingId/cancel", guest, cancelConferenceBookingHandler(repos.Bookings))
+	r.GET("/api/guest/conference/:id/bookings/:bookingId/tickets", guest, getConferenceBookingTickets(repos.Bookings, repos.Tickets))
+	r.POST("/api/guest/conference/:id/tickets/:ticketId/attendee", guest, assignAttendeeHandler(repos.Tickets))
+	r.GET("/api/guest/tickets/:id/pdf", guest, getTicketPDF(repos))
+	r.GET("/api/guest/tickets/:id/qr.png", guest, getTicketQRCode(repos))
+
 	// Bus endpoints
-	r.GET("/api/bus", getAllBuses)
-	r.POST("/api/bus", createBus)
-	r.GET("/api/bus/:id", getBusInfoByID)
-	r.GET("/api/bus/:id/bookings", getBusBookings)
-	r.POST("/api/bus/:id/book", bookBusTicketHandler)
-	r.GET("/api/bus/:id/seats", getBusSeats)
-	r.GET("/api/bus/:id/seats/available", getAvailableSeats)
+	r.GET("/api/routes", getAllRoutes(repos.Buses))
+	r.POST("/api/routes", managers, createRoute(repos.Buses))
+	r.GET("/api/vehicles", managers, getAllVehicles(repos.Buses))
+	r.POST("/api/vehicles", managers, createVehicle(repos.Buses))
+	r.GET("/api/trips", getAllTrips(repos.Buses))
+	r.POST("/api/trips", managers, createTrip(repos.Buses))
+	r.POST("/api/trips/:id/pricing", managers, setTripPricing(repos.Buses))
+	r.GET("/api/schedules", managers, getAllSchedules(repos.Schedules))
+	r.POST("/api/schedules", managers, createSchedule(repos.Schedules, repos.Buses))
+	r.DELETE("/api/schedules/:id", managers, deleteSchedule(repos.Schedules))
+	r.POST("/api/schedules/:id/pricing", managers, setSchedulePricing(repos.Schedules))
+	r.POST("/api/schedules/generate", managers, generateTripsHandler(repos.Schedules))
+	r.GET("/api/holidays", managers, getAllHolidays(repos.Schedules))
+	r.POST("/api/holidays", managers, createHoliday(repos.Schedules))
+	r.GET("/api/seat-layouts", managers, getAllSeatLayouts(repos.Buses))
+	r.POST("/api/seat-layouts", managers, createSeatLayout(repos.Buses))
+	r.GET("/api/bus", getAllBuses(repos.Buses))
+	r.POST("/api/bus", managers, createBus(repos.Buses))
+	r.GET("/api/bus/:id", getBusInfoByID(repos.Buses))
+	r.GET("/api/bus/:id/bookings", staff, getBusBookings(repos.Bookings))
+	r.POST("/api/bus/:id/quote", quoteBusHandler(repos.Buses))
+	r.POST("/api/bus/:id/book", IdempotencyMiddleware(conn), bookBusTicketHandler(repos.Bookings))
+	r.POST("/api/bus/:id/bookings/:bookingId/cancel", signedIn, cancelBusBookingHandler(repos.Bookings))
+	r.GET("/api/bus/:id/seats", getBusSeats(repos.Buses))
+	r.GET("/api/bus/:id/seats/available", getAvailableSeats(repos.Buses))
+	r.POST("/api/bus/:id/seats/hold", holdSeatsHandler(repos.Buses, repos.Bookings))
+	r.POST("/api/bus/:id/seats/release", releaseSeatsHandler(repos.Bookings))
 	// r.GET("/api/bus/:id/seats/all", getAllSeats)
 
+	// Ticket endpoints
+	r.POST("/api/tickets/verify", staff, verifyTicketHandler(repos.Tickets))
+	r.GET("/api/tickets/keys", getTicketKeys)
+	r.POST("/api/tickets/:id/checkin", staff, checkInTicketHandler(repos.Tickets))
+	r.GET("/api/tickets/:id/pdf", signedIn, getTicketPDF(repos))
+	r.GET("/api/tickets/:id/qr.png", signedIn, getTicketQRCode(repos))
+
 	// Conference endpoints
-	r.GET("/api/conferences", getAllConferences)
-	r.POST("/api/conferences", createConference)
-	r.GET("/api/conference/:id", getConferenceInfoByID)
-	r.GET("/api/conference/:id/bookings", getConferenceBookings)
-	r.POST("/api/conference/:id/book", bookConferenceTicketHandler)
+	r.GET("/api/conferences", getAllConferences(repos.Conferences))
+	r.POST("/api/conferences", managers, createConference(repos.Conferences))
+	r.GET("/api/conference/:id", getConferenceInfoByID(repos.Conferences))
+	r.GET("/api/conference/:id/bookings", staff, getConferenceBookings(repos.Bookings))
+	r.POST("/api/conference/:id/pricing", managers, setConferencePricing(repos.Conferences))
+	r.POST("/api/conference/:id/quote", quoteConferenceHandler(repos.Conferences))
+	r.POST("/api/conference/:id/book", IdempotencyMiddleware(conn), bookConferenceTicketHandler(repos.Conferences, repos.Bookings))
+	r.POST("/api/conference/:id/bookings/:bookingId/cancel", signedIn, cancelConferenceBookingHandler(repos.Bookings))
+	r.GET("/api/conference/:id/bookings/:bookingId/tickets", signedIn, getConferenceBookingTickets(repos.Bookings, repos.Tickets))
+	r.POST("/api/conference/:id/tickets/:ticketId/attendee", signedIn, assignAttendeeHandler(repos.Tickets))
 
 	// Dashboard endpoint
-	r.GET("/api/dashboard_summary", getDashboardSummary)
-	r.GET("/api/bus_bookings", getAllBusBookings)
-	r.GET("/api/conference_bookings", getAllConferenceBookings)
+	r.GET("/api/dashboard_summary", managers, getDashboardSummary(repos.Bookings))
+	r.GET("/api/bus_bookings", staff, getAllBusBookings(repos.Bookings, repos.Buses))
+	r.GET("/api/conference_bookings", staff, getAllConferenceBookings(repos.Bookings, repos.Conferences))
+
+	// Admin endpoints
+	admin := RequireRole(roleAdmin)
+	r.GET("/api/admin/outbox", admin, listOutboxMessages(conn))
+	r.POST("/api/admin/outbox/:id/replay", admin, replayOutboxMessage(conn))
+	r.GET("/api/admin/users", admin, getAllUsers(repos.Users))
+	r.POST("/api/admin/users/:id/role", admin, setUserRoleHandler(repos.Users))
+	r.GET("/api/admin/reconcile", admin, reconcileHandler(repos.Bookings))
+	r.POST("/api/admin/reconcile", admin, reconcileHandler(repos.Bookings))
 }
 
 // Function to handle CORS requests
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Guest-Token, Idempotency-Key")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -230,454 +326,487 @@ func CORSMiddleware() gin.HandlerFunc {
 }
 
 // Handler to book a conference ticket
-func bookConferenceTicketHandler(c *gin.Context) {
-	var booking ConferenceBooking
-	if err := c.ShouldBindJSON(&booking); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
+func bookConferenceTicketHandler(conferences ConferenceRepository, bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var booking ConferenceBooking
+		if err := c.ShouldBindJSON(&booking); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
 
-	if !ValidateUserInput(booking.FirstName, booking.LastName, booking.Email, booking.Tickets) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
-		return
-	}
+		if !ValidateUserInput(booking.FirstName, booking.LastName, booking.Email, booking.Tickets) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
 
-	// Find the conference by ID from the URL parameter
-	conferenceID := c.Param("id")
-	var conference Conference
-	if err := db.First(&conference, conferenceID).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Conference not found"})
-		return
-	}
+		// Find the conference by ID from the URL parameter
+		conferenceID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Conference not found"})
+			return
+		}
+		conference, err := conferences.GetConference(conferenceID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Conference not found"})
+			return
+		}
 
-	// Check if there are enough tickets available
-	if booking.Tickets > conference.RemainingTickets {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough tickets left", "remaining": conference.RemainingTickets})
-		return
+		quote, err := conferenceQuote(conference, booking.Tier, booking.Tickets)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		line := quote.Lines[0]
+		booking.Tier = line.Tier
+		booking.Currency = quote.Currency
+		booking.UnitPrice = line.UnitPrice
+		booking.Subtotal = quote.Subtotal
+		booking.Tax = quote.Tax
+		booking.Total = quote.Total
+
+		// Set the ConferenceID in the booking and link it to the signed-in customer
+		booking.ConferenceID = conference.ID
+		booking.UserID = currentUserID(c)
+		booking.BookedAt = time.Now()
+
+		booked, err := bookings.BookConference(&booking)
+		if err != nil {
+			respondBookingError(c, err, "Failed to book tickets")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Booking successful!",
+			"remaining":  booked.Remaining,
+			"bookingId":  booking.ID,
+			"ticketIDs":  booked.TicketIDs,
+			"ticketUrls": ticketURLs(booked.TicketIDs),
+			"quote":      quote,
+		})
 	}
+}
 
-	// Set the ConferenceID in the booking
-	booking.ConferenceID = conference.ID
+// Handler to cancel some or all tickets of a conference booking
+func cancelConferenceBookingHandler(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CancelRequest
+		// An empty body cancels the whole booking
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+				return
+			}
+		}
+		if req.Tickets < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid number of tickets"})
+			return
+		}
 
-	// Start a transaction
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+		conferenceID, okConference := idParam(c, "id")
+		bookingID, okBooking := idParam(c, "bookingId")
+		if !okConference || !okBooking {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
 		}
-	}()
 
-	// Update the conference remaining tickets
-	if err := tx.Model(&conference).Where("id = ?", conference.ID).Update("remaining_tickets",
-		gorm.Expr("remaining_tickets - ?", booking.Tickets)).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conference tickets"})
-		return
-	}
-	// Create the booking
-	if err := tx.Create(&booking).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking"})
-		return
-	}
+		cancelled, err := bookings.CancelConferenceBooking(conferenceID, bookingID, req.Tickets, req.Reason, callerCanManage(c))
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		case errors.Is(err, errNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot cancel this booking"})
+			return
+		case err != nil:
+			respondBookingError(c, err, "Failed to cancel booking")
+			return
+		}
 
-	// Commit the transaction
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
-		return
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Booking cancelled",
+			"status":    cancelled.Status,
+			"cancelled": cancelled.Cancelled,
+			"remaining": cancelled.Remaining,
+		})
 	}
-
-	// Async send ticket
-	waitgroup.Add(1)
-	go sendTicket(booking.Tickets, booking.FirstName, booking.LastName, booking.Email)
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":   "Booking successful!",
-		"remaining": conference.RemainingTickets - booking.Tickets,
-	})
 }
 
 // Handler to get all conferences
-func getAllConferences(c *gin.Context) {
-	var conferences []Conference
-	if err := db.Find(&conferences).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conferences"})
-		return
+func getAllConferences(conferences ConferenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := conferences.ListConferences()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conferences"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"conferences": list})
 	}
-	c.JSON(http.StatusOK, gin.H{"conferences": conferences})
 }
 
 // Handler to create a new conference
-func createConference(c *gin.Context) {
-	var conference Conference
-	if err := c.ShouldBindJSON(&conference); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-	if conference.Title == "" || conference.TotalTickets <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and TotalTickets are required"})
-		return
-	}
-	conference.RemainingTickets = conference.TotalTickets
-	if err := db.Create(&conference).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conference"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"conference": conference})
-}
-
-// Function to create a new bus
-func createBus(c *gin.Context) {
-	var bus Bus
-	if err := c.ShouldBindJSON(&bus); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
-		return
-	}
-
-	// Create the bus
-	if err := db.Create(&bus).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bus"})
-		return
-	}
-
-	// Create seats for this bus
-	var seats []BusSeat
-	for i := 1; i <= bus.TotalSeats; i++ {
-		seats = append(seats, BusSeat{
-			BusID:      bus.ID,
-			SeatNumber: i,
-			SeatStatus: "available",
-		})
-	}
-
-	if err := db.Create(&seats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create seats"})
-		return
+func createConference(conferences ConferenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var conference Conference
+		if err := c.ShouldBindJSON(&conference); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if conference.Title == "" || conference.TotalTickets <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title and TotalTickets are required"})
+			return
+		}
+		if err := validateConferenceTimes(conference); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if conference.TimeZone == "" {
+			conference.TimeZone = defaultTimeZone()
+		}
+		currency, err := normalizePricing(conference.Currency, conference.TaxBasisPoints)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tiers, err := normalizeTiers(conference.Tiers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		conference.Currency, conference.Tiers = currency, tiers
+		conference.RemainingTickets = conference.TotalTickets
+		if err := conferences.CreateConference(&conference); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create conference"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"conference": conference})
 	}
-
-	c.JSON(http.StatusOK, gin.H{"bus": bus})
 }
 
 // Handler to get all buses
-func getAllBuses(c *gin.Context) {
-	var buses []Bus
-	if err := db.Find(&buses).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch buses"})
-		return
+func getAllBuses(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := buses.ListTrips()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch buses"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"buses": list})
 	}
-	c.JSON(http.StatusOK, gin.H{"buses": buses})
 }
 
-// Handler to get bus seats
+// Handler to get bus seats, for the journey between ?boardingStopId= and ?alightingStopId=
+// when given and for the whole trip otherwise
+func getBusSeats(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
 
-// Handler function
-func getBusSeats(c *gin.Context) {
-	id := c.Param("id")
+		// Verify bus exists
+		bus, err := buses.GetTrip(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
 
-	// Verify bus exists
-	var bus Bus
-	if err := db.First(&bus, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
-		return
-	}
+		boardingStopID, alightingStopID, ok := journeyParams(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stop ID"})
+			return
+		}
+		journey, err := tripJourney(bus, boardingStopID, alightingStopID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	// Get all seats for this bus
-	var seats []BusSeat
-	if err := db.Where("bus_id = ?", id).Find(&seats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seats"})
-		return
-	}
+		// Get all seats for this bus
+		seats, err := buses.Seats(id, journey.From, journey.To)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seats"})
+			return
+		}
+		layout, err := buses.TripSeatLayout(bus)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seat layout"})
+			return
+		}
+		grid, seatsOnMap := seatMap(layout, seats)
 
-	// Format response
-	var seatList []map[string]interface{}
-	for _, seat := range seats {
-		seatList = append(seatList, map[string]interface{}{
-			"seatNumber": seat.SeatNumber,
-			"status":     seat.SeatStatus,
+		c.JSON(http.StatusOK, gin.H{
+			"bus":     bus,
+			"journey": journey,
+			"layout":  grid,
+			"seats":   seatsOnMap,
 		})
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"bus":   bus,
-		"seats": seatList,
-	})
 }
 
 // Handler to get conference info by ID
-func getConferenceInfoByID(c *gin.Context) {
-	id := c.Param("id")
-	var conference Conference
-	if err := db.First(&conference, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
-		return
+func getConferenceInfoByID(conferences ConferenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
+			return
+		}
+		conference, err := conferences.GetConference(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"conference": conference})
 	}
-	c.JSON(http.StatusOK, gin.H{"conference": conference})
 }
 
 // Handler to get all bookings for a specific conference
-func getConferenceBookings(c *gin.Context) {
-	var bookings []ConferenceBooking
-	conferenceID := c.Param("id")
+func getConferenceBookings(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conferenceID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conference ID"})
+			return
+		}
 
-	if err := db.Where("conference_id = ?", conferenceID).Find(&bookings).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings for the conference"})
-		return
-	}
+		list, err := bookings.ConferenceBookings(conferenceID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings for the conference"})
+			return
+		}
 
-	c.JSON(http.StatusOK, gin.H{"bookings": bookings})
+		c.JSON(http.StatusOK, gin.H{"bookings": list})
+	}
 }
 
 // GetBusInfoByID returns a bus by its ID
-func getBusInfoByID(c *gin.Context) {
-	id := c.Param("id")
-	var bus Bus
-	if err := db.First(&bus, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
-		return
+func getBusInfoByID(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+		bus, err := buses.GetTrip(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"bus": bus})
 	}
-	c.JSON(http.StatusOK, gin.H{"bus": bus})
 }
 
 // Handler to get bookings for a specific bus
-func getBusBookings(c *gin.Context) {
-	var bookings []BusBooking
-	busID := c.Param("id")
+func getBusBookings(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tripID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bus ID"})
+			return
+		}
 
-	if err := db.Where("bus_id = ?", busID).Find(&bookings).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings for the bus"})
-		return
-	}
+		list, err := bookings.BusBookings(tripID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings for the bus"})
+			return
+		}
 
-	c.JSON(http.StatusOK, gin.H{"bookings": bookings})
+		c.JSON(http.StatusOK, gin.H{"bookings": list})
+	}
 }
 
 // Handler to book a bus ticket
-func bookBusTicketHandler(c *gin.Context) {
-
-	var bookingRequest BookingRequest
-
-	// Use ShouldBindJSON to properly parse the request body
-	if err := c.ShouldBindJSON(&bookingRequest); err != nil {
-		log.Printf("Error binding JSON: %v", err)
-		log.Printf("Request body: %v", c.Request.Body)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid request body",
-			"details": err.Error(),
-		})
-		return
-	}
-
-	log.Printf("Booking request received: %+v", bookingRequest)
+func bookBusTicketHandler(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bookingRequest BookingRequest
+
+		// Use ShouldBindJSON to properly parse the request body
+		if err := c.ShouldBindJSON(&bookingRequest); err != nil {
+			log.Printf("Error binding JSON: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
 
-	if len(bookingRequest.SelectedSeats) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No seat selected"})
-		return
-	}
+		log.Printf("Booking request received: %+v", bookingRequest)
 
-	busIDParam := c.Param("id")
-	var busID uint
-	if _, err := fmt.Sscanf(busIDParam, "%d", &busID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bus ID in URL"})
-		return
-	}
+		if len(bookingRequest.SelectedSeats) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No seat selected"})
+			return
+		}
 
-	if !ValidateUserInput(bookingRequest.FirstName, bookingRequest.LastName, bookingRequest.Email, 1) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
-		return
-	}
+		tripID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bus ID in URL"})
+			return
+		}
 
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+		if !ValidateUserInput(bookingRequest.FirstName, bookingRequest.LastName, bookingRequest.Email, 1) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
 		}
-	}()
 
-	// Get bus info once
-	var bus Bus
-	if err := tx.First(&bus, busID).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bus not found"})
-		return
-	}
+		// Deadlocks between concurrent bookings are resolved by the database aborting one
+		// of them, so the whole transaction is retried a few times before giving up
+		var booked SeatBooking
+		userID := currentUserID(c)
+		err := withDeadlockRetry(func() error {
+			var err error
+			booked, err = bookings.BookSeats(bookingRequest, tripID, userID)
+			return err
+		})
+		if err != nil {
+			respondBookingError(c, err, "Failed to book seats")
+			return
+		}
 
-	if len(bookingRequest.SelectedSeats) > bus.RemainingSeats {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough seats available"})
-		return
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Seats booked successfully!",
+			"remaining":    booked.Remaining,
+			"seatNumbers":  booked.SeatNumbers,
+			"ticketIDs":    booked.TicketIDs,
+			"ticketUrls":   ticketURLs(booked.TicketIDs),
+			"ticketTokens": booked.TicketTokens,
+			"quote":        booked.Quote,
+		})
 	}
+}
 
-	// Create a slice to store all created bookings
-	var bookings []BusBooking
-	var ticketIDs []string
-
-	// Loop through selected seats and process each one
-	for _, seatNum := range bookingRequest.SelectedSeats {
-		var seat BusSeat
-		if err := tx.Set("gorm:query_option", "FOR UPDATE").
-			Where("bus_id = ? AND seat_number = ?", busID, seatNum).
-			First(&seat).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Seat %d not found", seatNum)})
+// Handler to cancel a bus booking, releasing its seat and revoking its ticket
+func cancelBusBookingHandler(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tripID, okTrip := idParam(c, "id")
+		bookingID, okBooking := idParam(c, "bookingId")
+		if !okTrip || !okBooking {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
 			return
 		}
 
-		if seat.SeatStatus != "available" {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Seat %d already booked", seatNum)})
+		booking, err := bookings.CancelBusBooking(tripID, bookingID, callerCanManage(c))
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		case errors.Is(err, errNotAllowed):
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot cancel this booking"})
+			return
+		case err != nil:
+			respondBookingError(c, err, "Failed to cancel booking")
 			return
 		}
 
-		booking := BusBooking{
-			FirstName:   bookingRequest.FirstName,
-			LastName:    bookingRequest.LastName,
-			Email:       bookingRequest.Email,
-			SeatNumber:  seatNum,
-			BusID:       busID,
-			BusName:     bus.Name,
-			BookingDate: time.Now().Format("2006-01-02"),
-			BookingTime: time.Now().Format("15:04:05"),
-		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Booking cancelled",
+			"bookingId":  booking.ID,
+			"seatNumber": booking.SeatNumber,
+		})
+	}
+}
 
-		if err := tx.Create(&booking).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking"})
+// Handler to get available seats for a bus, for the journey between ?boardingStopId= and
+// ?alightingStopId= when given and for the whole trip otherwise
+func getAvailableSeats(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tripID, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bus ID"})
 			return
 		}
-
-		if err := tx.Model(&seat).Updates(map[string]interface{}{
-			"seat_status": "booked",
-			"booking_id":  booking.ID,
-		}).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update seat status"})
+		boardingStopID, alightingStopID, ok := journeyParams(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stop ID"})
 			return
 		}
-
-		// Create a ticket for the booking
-		ticket := BusTicket{
-			ID:           uuid.New().String(),
-			BusBookingID: booking.ID,
-			BusID:        busID,
-			SeatNumber:   seatNum,
-			FirstName:    bookingRequest.FirstName,
-			LastName:     bookingRequest.LastName,
-			Email:        bookingRequest.Email,
-			Used:         false,
-			CreatedAt:    time.Now(),
+		trip, err := buses.GetTrip(tripID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
 		}
-		if err := tx.Create(&ticket).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+		journey, err := tripJourney(trip, boardingStopID, alightingStopID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		ticketIDs = append(ticketIDs, ticket.ID)
-	}
-
-	// Update bus remaining seats in bulk
-	if err := tx.Model(&bus).Update("remaining_seats", gorm.Expr("remaining_seats - ?", len(bookingRequest.SelectedSeats))).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bus seats"})
-		return
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
-		return
-	}
 
-	// Send tickets asynchronously
-	waitgroup.Add(1)
-	go func() {
-		defer waitgroup.Done()
-		for _, booking := range bookings {
-			sendTicket(1, booking.FirstName, booking.LastName, booking.Email)
+		seats, err := buses.AvailableSeats(tripID, journey.From, journey.To)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch available seats"})
+			return
 		}
-	}()
-
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Seats booked successfully!",
-		"remaining":   bus.RemainingSeats - len(bookingRequest.SelectedSeats),
-		"seatNumbers": bookingRequest.SelectedSeats,
-		"ticketIDs":   ticketIDs,
-	})
-}
-
-// Handler to get available seats for a bus
-func getAvailableSeats(c *gin.Context) {
-	busID := c.Param("id")
-	var seats []BusSeat
 
-	if err := db.Where("bus_id = ? AND seat_status = ?", busID, "available").Find(&seats).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch available seats"})
-		return
+		c.JSON(http.StatusOK, gin.H{"available_seats": seats})
 	}
-
-	c.JSON(http.StatusOK, gin.H{"available_seats": seats})
 }
 
 // Handler to get all bus bookings with bus name
-func getAllBusBookings(c *gin.Context) {
-	var bookings []BusBooking
-	if err := db.Find(&bookings).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bus bookings"})
-		return
-	}
+func getAllBusBookings(bookings BookingRepository, buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := bookings.AllBusBookings()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bus bookings"})
+			return
+		}
 
-	var result []map[string]interface{}
-	for _, booking := range bookings {
-		var bus Bus
-		db.First(&bus, booking.BusID)
-		m := map[string]interface{}{
-			"ID":        booking.ID,
-			"firstName": booking.FirstName,
-			"lastName":  booking.LastName,
-			"email":     booking.Email,
-			"seats":     booking.SeatNumber,
-			"busname":   bus.Name, // Add bus name
-			"CreatedAt": booking.CreatedAt,
-			"Status":    booking.Status,
-		}
-		result = append(result, m)
+		var result []map[string]interface{}
+		names := map[uint]string{}
+		for _, booking := range list {
+			name, ok := names[booking.TripID]
+			if !ok {
+				trip, _ := buses.GetTrip(booking.TripID)
+				name = trip.Vehicle.Name
+				names[booking.TripID] = name
+			}
+			m := map[string]interface{}{
+				"ID":        booking.ID,
+				"firstName": booking.FirstName,
+				"lastName":  booking.LastName,
+				"email":     booking.Email,
+				"seats":     booking.SeatNumber,
+				"busname":   name, // Add bus name
+				"CreatedAt": booking.CreatedAt,
+				"Status":    booking.Status,
+			}
+			result = append(result, m)
+		}
+		c.JSON(http.StatusOK, gin.H{"bookings": result})
 	}
-	c.JSON(http.StatusOK, gin.H{"bookings": result})
 }
 
 // Handler to get all conference bookings with conference name
-func getAllConferenceBookings(c *gin.Context) {
-	var bookings []ConferenceBooking
-	if err := db.Find(&bookings).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conference bookings"})
-		return
-	}
+func getAllConferenceBookings(bookings BookingRepository, conferences ConferenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := bookings.AllConferenceBookings()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conference bookings"})
+			return
+		}
 
-	var result []map[string]interface{}
-	for _, booking := range bookings {
-		var conf Conference
-		db.First(&conf, booking.ConferenceID)
-		m := map[string]interface{}{
-			"ID":             booking.ID,
-			"firstName":      booking.FirstName,
-			"lastName":       booking.LastName,
-			"email":          booking.Email,
-			"tickets":        booking.Tickets,
-			"conferenceName": conf.Title, // Add conference name
-			"CreatedAt":      booking.CreatedAt,
-		}
-		result = append(result, m)
+		var result []map[string]interface{}
+		titles := map[uint]string{}
+		for _, booking := range list {
+			title, ok := titles[booking.ConferenceID]
+			if !ok {
+				conf, _ := conferences.GetConference(booking.ConferenceID)
+				title = conf.Title
+				titles[booking.ConferenceID] = title
+			}
+			m := map[string]interface{}{
+				"ID":             booking.ID,
+				"firstName":      booking.FirstName,
+				"lastName":       booking.LastName,
+				"email":          booking.Email,
+				"tickets":        booking.Tickets,
+				"conferenceName": title, // Add conference name
+				"CreatedAt":      booking.CreatedAt,
+				"Status":         booking.Status,
+			}
+			result = append(result, m)
+		}
+		c.JSON(http.StatusOK, gin.H{"bookings": result})
 	}
-	c.JSON(http.StatusOK, gin.H{"bookings": result})
-}
-
-// Function to send a ticket  asynchronously
-func sendTicket(count int, firstName, lastName, email string) {
-	defer waitgroup.Done()
-
-	time.Sleep(10 * time.Second)
-	ticket := fmt.Sprintf("%d tickets for %s %s", count, firstName, lastName)
-	log.Printf("Sending %d ticket(s) to %s %s at %s\n", count, firstName, lastName, email)
-
-	log.Printf("Sending ticket to %s: %s\n", email, ticket)
 }
 
 // ValidateUserInput checks if the user input is valid
@@ -689,29 +818,43 @@ func ValidateUserInput(firstName, lastName, email string, tickets int) bool {
 	return isValidName && isValidEmail && isValidTicketNumber
 }
 
+// idParam parses a numeric ID from the URL
+func idParam(c *gin.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// respondBookingError reports a refused or failed booking change: a rule the request
+// broke as 400, or 409 when another customer got there first, anything else as failure
+func respondBookingError(c *gin.Context, err error, failure string) {
+	var rule *RuleError
+	if errors.As(err, &rule) {
+		status := http.StatusBadRequest
+		if rule.Conflict {
+			status = http.StatusConflict
+		}
+		body := gin.H{"error": rule.Message}
+		for k, v := range rule.Details {
+			body[k] = v
+		}
+		c.JSON(status, body)
+		return
+	}
+	log.Printf("%s: %v", failure, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+}
+
 // Handler to get the dashboard summary
-func getDashboardSummary(c *gin.Context) {
-	var busCount, conferenceCount, busBookingCount, conferenceBookingCount int64
-	var totalBusSeats, totalConferenceTickets sql.NullInt64
-	var totalBusSeatsBooked, totalConferenceTicketsBooked int64
-
-	db.Model(&Bus{}).Count(&busCount)
-	db.Model(&Conference{}).Count(&conferenceCount)
-	db.Model(&BusBooking{}).Count(&busBookingCount)
-	db.Model(&ConferenceBooking{}).Count(&conferenceBookingCount)
-	db.Model(&Bus{}).Select("SUM(total_seats)").Scan(&totalBusSeats)
-	db.Model(&Conference{}).Select("SUM(total_tickets)").Scan(&totalConferenceTickets)
-	db.Model(&BusBooking{}).Select("SUM(tickets)").Scan(&totalBusSeatsBooked)
-	db.Model(&ConferenceBooking{}).Select("SUM(tickets)").Scan(&totalConferenceTicketsBooked)
-
-	c.JSON(200, gin.H{
-		"busCount":                     busCount,
-		"conferenceCount":              conferenceCount,
-		"busBookingCount":              busBookingCount,
-		"conferenceBookingCount":       conferenceBookingCount,
-		"totalBusSeats":                totalBusSeats,
-		"totalConferenceTickets":       totalConferenceTickets,
-		"totalBusSeatsBooked":          totalBusSeatsBooked,
-		"totalConferenceTicketsBooked": totalConferenceTicketsBooked,
-	})
+func getDashboardSummary(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		summary, err := bookings.Summary()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch summary"})
+			return
+		}
+		c.JSON(http.StatusOK, summary)
+	}
 }
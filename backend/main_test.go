@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	jwtSecret = []byte("test-secret")
+	initTicketSigning()
+	os.Exit(m.Run())
+}
+
+// useTestDB migrates conn and routes mail to memory
+func useTestDB(t *testing.T, conn *gorm.DB) *MemoryMailer {
+	t.Helper()
+	if _, err := migrateUp(conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	previousMailer := mailer
+	sent := &MemoryMailer{}
+	mailer = sent
+	t.Cleanup(func() { mailer = previousMailer })
+	return sent
+}
+
+// openMemoryDB opens a private in-memory SQLite database, configured like DB_DRIVER=memory
+func openMemoryDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return conn
+}
+
+// openConcurrentDB opens a database whose connections run queries at the same time:
+// the Postgres server at TEST_DATABASE_URL when it is set, otherwise a SQLite file in
+// WAL mode with a pool of connections. SQLite drops the row locks, so its transactions
+// take the write lock when they begin instead, much like the locks they would take.
+func openConcurrentDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	if os.Getenv("TEST_DATABASE_URL") != "" {
+		return openPostgresDB(t)
+	}
+	path := filepath.Join(t.TempDir(), "bookings.db")
+	conn, err := gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_txlock=immediate"),
+		&gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(8)
+	t.Cleanup(func() { sqlDB.Close() })
+	return conn
+}
+
+// newTestRouter returns the API as served by main, on conn
+func newTestRouter(conn *gorm.DB) *gin.Engine {
+	repos := newRepositories(conn)
+	r := gin.New()
+	r.Use(AuthMiddleware(repos.Users))
+	setupRoutes(r, conn, repos)
+	return r
+}
+
+// doJSON sends a JSON request and decodes the JSON response into out, when given
+func doJSON(t *testing.T, r http.Handler, method, path string, body interface{}, header http.Header, out interface{}) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if out != nil && w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+// createTestTrip stores a priced trip with the given number of seats on a route calling
+// at stops, or on a two stop route when none are given. The trip has its route loaded.
+func createTestTrip(t *testing.T, repos Repositories, name string, seats int, stops ...string) Trip {
+	t.Helper()
+	route := Route{
+		Name:        routeName("Origin "+name, "Destination "+name),
+		Origin:      "Origin " + name,
+		Destination: "Destination " + name,
+		TimeZone:    "UTC",
+		Stops:       routeStops("Origin "+name, "Destination "+name),
+	}
+	if len(stops) > 0 {
+		route.Origin, route.Destination = stops[0], stops[len(stops)-1]
+		route.Stops = nil
+		for i, stop := range stops {
+			route.Stops = append(route.Stops, RouteStop{Sequence: i, Name: stop})
+		}
+	}
+	if err := repos.Buses.CreateRoute(&route); err != nil {
+		t.Fatalf("create route: %v", err)
+	}
+	vehicle := Vehicle{Name: name, Capacity: seats}
+	if err := repos.Buses.CreateVehicle(&vehicle); err != nil {
+		t.Fatalf("create vehicle: %v", err)
+	}
+	departs := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Minute)
+	trip := Trip{
+		RouteID:        route.ID,
+		VehicleID:      vehicle.ID,
+		DepartsAt:      departs,
+		ArrivesAt:      departs.Add(3 * time.Hour),
+		TotalSeats:     seats,
+		RemainingSeats: seats,
+		Currency:       "EUR",
+		Fares:          []TripFare{{SeatClass: defaultSeatClass, Price: 2500}},
+	}
+	if err := repos.Buses.CreateTrip(&trip); err != nil {
+		t.Fatalf("create trip: %v", err)
+	}
+	trip.Route = route
+	return trip
+}
+
+// bookTestSeat books a seat of the trip for email and returns the booking ID
+func bookTestSeat(t *testing.T, db *gorm.DB, r http.Handler, trip Trip, seat int, email string) uint {
+	t.Helper()
+	var booked struct {
+		TicketIDs []string `json:"ticketIDs"`
+	}
+	status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+		FirstName: "Gus", LastName: "Guest", Email: email, SelectedSeats: []int{seat},
+	}, nil, &booked)
+	if status != http.StatusOK {
+		t.Fatalf("book seat %d: status %d", seat, status)
+	}
+	ticket, err := newRepositories(db).Tickets.GetBusTicket(booked.TicketIDs[0])
+	if err != nil {
+		t.Fatalf("load ticket: %v", err)
+	}
+	return ticket.BusBookingID
+}
+
+// signIn creates a user with the given role and returns the header of its session
+func signIn(t *testing.T, conn *gorm.DB, email, role string) http.Header {
+	t.Helper()
+	user := User{Email: email, FirstName: "Test", Role: role}
+	if err := conn.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	token, err := issueToken(user)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestSegmentResale(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	repos := newRepositories(db)
+	owner := signIn(t, db, "rider@example.com", roleCustomer)
+
+	trip := createTestTrip(t, repos, "Segment Coach", 2, "A", "B", "C", "D")
+	stop := map[string]uint{}
+	for _, s := range trip.Route.Stops {
+		stop[s.Name] = s.ID
+	}
+
+	bookings := map[string]uint{}
+	book := func(from, to string) int {
+		var resp struct {
+			TicketIDs []string `json:"ticketIDs"`
+		}
+		status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+			FirstName:       "Rita",
+			LastName:        "Rider",
+			Email:           "rider@example.com",
+			SelectedSeats:   []int{1},
+			BoardingStopID:  stop[from],
+			AlightingStopID: stop[to],
+		}, owner, &resp)
+		if status == http.StatusOK {
+			ticket, err := repos.Tickets.GetBusTicket(resp.TicketIDs[0])
+			if err != nil {
+				t.Fatalf("load ticket: %v", err)
+			}
+			bookings[from+to] = ticket.BusBookingID
+		}
+		return status
+	}
+	cancel := func(journey string) int {
+		return doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/bookings/%d/cancel", trip.ID, bookings[journey]), nil, owner, nil)
+	}
+
+	// Seat 1 over the segments A-B, B-C and C-D, and the seats free for the whole trip
+	for _, step := range []struct {
+		name      string
+		do        func() int
+		status    int
+		segments  []string
+		remaining int
+	}{
+		{"book A to B", func() int { return book("A", "B") }, http.StatusOK, []string{"booked", "available", "available"}, 1},
+		{"resell B to D", func() int { return book("B", "D") }, http.StatusOK, []string{"booked", "booked", "booked"}, 1},
+		{"book A to C", func() int { return book("A", "C") }, http.StatusConflict, []string{"booked", "booked", "booked"}, 1},
+		{"cancel A to B", func() int { return cancel("AB") }, http.StatusOK, []string{"available", "booked", "booked"}, 1},
+		{"cancel B to D", func() int { return cancel("BD") }, http.StatusOK, []string{"available", "available", "available"}, 2},
+	} {
+		if status := step.do(); status != step.status {
+			t.Fatalf("%s: status %d, want %d", step.name, status, step.status)
+		}
+
+		var rows []BusSeat
+		if err := db.Where("trip_id = ? AND seat_number = ?", trip.ID, 1).Order("segment").Find(&rows).Error; err != nil {
+			t.Fatalf("%s: load seat: %v", step.name, err)
+		}
+		var segments []string
+		for _, row := range rows {
+			segments = append(segments, row.SeatStatus)
+		}
+		if fmt.Sprint(segments) != fmt.Sprint(step.segments) {
+			t.Errorf("%s: segments %v, want %v", step.name, segments, step.segments)
+		}
+
+		var stored Trip
+		if err := db.First(&stored, trip.ID).Error; err != nil {
+			t.Fatalf("%s: load trip: %v", step.name, err)
+		}
+		if stored.RemainingSeats != step.remaining {
+			t.Errorf("%s: remaining seats %d, want %d", step.name, stored.RemainingSeats, step.remaining)
+		}
+	}
+}
+
+func TestOverlappingBusCancellations(t *testing.T) {
+	db := openConcurrentDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	staff := signIn(t, db, fmt.Sprintf("desk%d@example.com", time.Now().UnixNano()), roleAgent)
+
+	// Each scenario books seat 1, then cancels that booking and books the seat again
+	// from several clients at the same time
+	for _, tc := range []struct {
+		name    string
+		cancels int
+		books   int
+	}{
+		{"cancel twice", 4, 0},
+		{"cancel while rebooking", 1, 3},
+		{"cancel twice while rebooking", 3, 3},
+	} {
+		trip := createTestTrip(t, newRepositories(db), fmt.Sprintf("Overlap %d", time.Now().UnixNano()), 3)
+		booking := bookTestSeat(t, db, r, trip, 1, "first@example.com")
+
+		cancelled, rebooked := make(chan int, tc.cancels), make(chan int, tc.books)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < tc.cancels+tc.books; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				if i < tc.cancels {
+					cancelled <- doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/bookings/%d/cancel", trip.ID, booking), nil, staff, nil)
+					return
+				}
+				rebooked <- doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+					FirstName: "Ole", LastName: "Overlap", Email: "ole@example.com", SelectedSeats: []int{1},
+				}, nil, nil)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+		close(cancelled)
+		close(rebooked)
+
+		// One cancellation wins and the others are told it is already cancelled; a
+		// booking either gets the freed seat or is told it is taken
+		count := func(statuses chan int, ok, refused int) (succeeded int) {
+			for status := range statuses {
+				switch status {
+				case ok:
+					succeeded++
+				case refused:
+				default:
+					t.Errorf("%s: request failed with status %d", tc.name, status)
+				}
+			}
+			return succeeded
+		}
+		if n := count(cancelled, http.StatusOK, http.StatusBadRequest); n != 1 {
+			t.Errorf("%s: %d cancellations succeeded, want 1", tc.name, n)
+		}
+		seated := count(rebooked, http.StatusOK, http.StatusConflict)
+		if seated > 1 {
+			t.Errorf("%s: seat 1 was booked again %d times", tc.name, seated)
+		}
+
+		// The seat, the trip's counter and the tickets agree with the bookings left
+		var booked []BusBooking
+		db.Where("trip_id = ? AND status = ?", trip.ID, "confirmed").Find(&booked)
+		var seat BusSeat
+		db.Where("trip_id = ? AND seat_number = ?", trip.ID, 1).First(&seat)
+		var stored Trip
+		db.First(&stored, trip.ID)
+		var valid int64
+		db.Model(&BusTicket{}).Where("trip_id = ? AND revoked = ?", trip.ID, false).Count(&valid)
+		switch {
+		case len(booked) != seated:
+			t.Errorf("%s: %d confirmed bookings, want %d", tc.name, len(booked), seated)
+		case seated == 0 && (seat.SeatStatus != "available" || seat.BookingID != 0):
+			t.Errorf("%s: seat 1 is %s for booking %d, want it available", tc.name, seat.SeatStatus, seat.BookingID)
+		case seated == 1 && (seat.SeatStatus != "booked" || seat.BookingID != booked[0].ID):
+			t.Errorf("%s: seat 1 is %s for booking %d, want it booked for %d", tc.name, seat.SeatStatus, seat.BookingID, booked[0].ID)
+		}
+		if stored.RemainingSeats != 3-seated || valid != int64(seated) {
+			t.Errorf("%s: %d seats left and %d valid tickets for %d bookings", tc.name, stored.RemainingSeats, valid, seated)
+		}
+	}
+}
+
+func TestPartialConferenceCancellation(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	owner := signIn(t, db, "carla@example.com", roleCustomer)
+
+	conference := Conference{Title: "Refund Conf", Location: "Hall E", TimeZone: "UTC", TotalTickets: 10, RemainingTickets: 10,
+		StartsAt: time.Now().Add(24 * time.Hour), EndsAt: time.Now().Add(48 * time.Hour), Currency: "EUR", Free: true}
+	if err := db.Create(&conference).Error; err != nil {
+		t.Fatalf("create conference: %v", err)
+	}
+	var booked struct {
+		BookingID uint `json:"bookingId"`
+	}
+	if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/conference/%d/book", conference.ID), ConferenceBooking{
+		FirstName: "Carla", LastName: "Canceller", Email: "carla@example.com", Tickets: 5,
+	}, owner, &booked); status != http.StatusOK {
+		t.Fatalf("book: status %d", status)
+	}
+	path := fmt.Sprintf("/api/conference/%d/bookings/%d/cancel", conference.ID, booked.BookingID)
+
+	// Each step cancels some tickets of the booking of 5, then checks what is left
+	for _, step := range []struct {
+		name      string
+		tickets   int
+		status    int
+		error     string
+		remaining int // tickets left for sale
+		booked    int // tickets left on the booking
+		state     string
+		revoked   int64
+		records   int64 // cancellation records
+	}{
+		{"cancel 2", 2, http.StatusOK, "", 7, 3, "partially_cancelled", 2, 1},
+		{"cancel more than booked", 4, http.StatusBadRequest, "Cannot cancel more tickets than booked", 7, 3, "partially_cancelled", 2, 1},
+		{"cancel a negative number", -1, http.StatusBadRequest, "Invalid number of tickets", 7, 3, "partially_cancelled", 2, 1},
+		{"cancel the rest", 3, http.StatusOK, "", 10, 0, "cancelled", 5, 2},
+		{"cancel again", 1, http.StatusBadRequest, "Booking already cancelled", 10, 0, "cancelled", 5, 2},
+	} {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		status := doJSON(t, r, http.MethodPost, path, CancelRequest{Tickets: step.tickets}, owner, &resp)
+		if status != step.status || resp.Error != step.error {
+			t.Errorf("%s: status %d %q, want %d %q", step.name, status, resp.Error, step.status, step.error)
+		}
+
+		var stored Conference
+		db.First(&stored, conference.ID)
+		var booking ConferenceBooking
+		db.First(&booking, booked.BookingID)
+		var revoked, records int64
+		db.Model(&ConferenceTicket{}).Where("conference_booking_id = ? AND revoked = ?", booking.ID, true).Count(&revoked)
+		db.Model(&ConferenceCancellation{}).Where("conference_booking_id = ?", booking.ID).Count(&records)
+		if stored.RemainingTickets != step.remaining || booking.Tickets != step.booked || booking.Status != step.state ||
+			revoked != step.revoked || records != step.records {
+			t.Errorf("%s: %d for sale, booking of %d %s, %d revoked, %d cancellations; want %d, %d %s, %d, %d", step.name,
+				stored.RemainingTickets, booking.Tickets, booking.Status, revoked, records,
+				step.remaining, step.booked, step.state, step.revoked, step.records)
+		}
+	}
+}
+
+func TestBusTicketCheckIn(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	repos := newRepositories(db)
+	driver := signIn(t, db, "driver@example.com", roleAgent)
+
+	trip := createTestTrip(t, repos, "Boarding Coach", 3)
+	other := createTestTrip(t, repos, "Other Coach", 3)
+	ticketOf := func(booking uint) string {
+		var ticket BusTicket
+		if err := db.Where("bus_booking_id = ?", booking).First(&ticket).Error; err != nil {
+			t.Fatalf("load ticket: %v", err)
+		}
+		return ticket.ID
+	}
+	ticket := ticketOf(bookTestSeat(t, db, r, trip, 1, "bea@example.com"))
+	cancelledBooking := bookTestSeat(t, db, r, trip, 2, "carl@example.com")
+	if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/bookings/%d/cancel", trip.ID, cancelledBooking), nil, driver, nil); status != http.StatusOK {
+		t.Fatalf("cancel: status %d", status)
+	}
+	cancelled := ticketOf(cancelledBooking)
+
+	for _, tc := range []struct {
+		name   string
+		ticket string
+		header http.Header
+		req    CheckInRequest
+		status int
+		error  string
+	}{
+		{"signed out", ticket, nil, CheckInRequest{TripID: trip.ID, DeviceID: "gate-1"}, http.StatusUnauthorized, ""},
+		{"no device", ticket, driver, CheckInRequest{TripID: trip.ID}, http.StatusBadRequest, "tripId and deviceId are required"},
+		{"unknown ticket", "00000000-0000-0000-0000-000000000000", driver, CheckInRequest{TripID: trip.ID, DeviceID: "gate-1"}, http.StatusNotFound, "Ticket not found"},
+		{"wrong trip", ticket, driver, CheckInRequest{TripID: other.ID, DeviceID: "gate-1"}, http.StatusBadRequest, "Ticket is not valid for this bus"},
+		{"cancelled ticket", cancelled, driver, CheckInRequest{TripID: trip.ID, DeviceID: "gate-1"}, http.StatusBadRequest, "Ticket has been cancelled"},
+		{"first scan", ticket, driver, CheckInRequest{TripID: trip.ID, DeviceID: "gate-1"}, http.StatusOK, ""},
+		{"second scan", ticket, driver, CheckInRequest{TripID: trip.ID, DeviceID: "gate-2"}, http.StatusConflict, "Ticket already checked in"},
+		{"second scan by an old scanner", ticket, driver, CheckInRequest{BusID: trip.ID, DeviceID: "gate-3"}, http.StatusConflict, "Ticket already checked in"},
+	} {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		status := doJSON(t, r, http.MethodPost, "/api/tickets/"+tc.ticket+"/checkin", tc.req, tc.header, &resp)
+		if status != tc.status || (tc.error != "" && resp.Error != tc.error) {
+			t.Errorf("%s: status %d %q, want %d %q", tc.name, status, resp.Error, tc.status, tc.error)
+		}
+	}
+
+	// Only the first scan is recorded
+	var stored BusTicket
+	if err := db.First(&stored, "id = ?", ticket).Error; err != nil {
+		t.Fatalf("load ticket: %v", err)
+	}
+	if !stored.Used || stored.UsedAt == nil || stored.CheckedInBy != "gate-1" {
+		t.Errorf("ticket used %t at %v by %q, want used by gate-1", stored.Used, stored.UsedAt, stored.CheckedInBy)
+	}
+	var revoked BusTicket
+	if err := db.First(&revoked, "id = ?", cancelled).Error; err != nil || revoked.Used {
+		t.Errorf("cancelled ticket %+v, %v; want it unused", revoked, err)
+	}
+}
+
+func TestMyBookingsLoadTheirTrips(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	owner := signIn(t, db, "tess@example.com", roleCustomer)
+	trip := createTestTrip(t, newRepositories(db), "Listed Coach", 2)
+	if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+		FirstName: "Tess", LastName: "Trip", Email: "tess@example.com", SelectedSeats: []int{1},
+	}, owner, nil); status != http.StatusOK {
+		t.Fatalf("book: status %d", status)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		delete func() error
+		status int
+	}{
+		{"trip deleted", func() error { return db.Delete(&Trip{}, trip.ID).Error }, http.StatusOK},
+		{"trip missing", func() error { return db.Unscoped().Delete(&Trip{}, trip.ID).Error }, http.StatusInternalServerError},
+	} {
+		if err := tc.delete(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if status := doJSON(t, r, http.MethodGet, "/api/me/bookings", nil, owner, nil); status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+	}
+}
+
+func TestMyBookingsAreTheCustomersOwn(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	trip := createTestTrip(t, newRepositories(db), "Portal Coach", 4)
+	conference := Conference{Title: "Portal Conf", Location: "Hall F", TimeZone: "UTC", TotalTickets: 10, RemainingTickets: 10,
+		StartsAt: time.Now().Add(24 * time.Hour), EndsAt: time.Now().Add(48 * time.Hour), Currency: "EUR", Free: true}
+	if err := db.Create(&conference).Error; err != nil {
+		t.Fatalf("create conference: %v", err)
+	}
+
+	// Ann and Bob each book a seat and a ticket; a guest books with Ann's email
+	type customer struct {
+		header     http.Header
+		busTicket  string
+		busBooking uint
+	}
+	book := func(header http.Header, email string, seat int) customer {
+		var seats struct {
+			TicketIDs []string `json:"ticketIDs"`
+		}
+		if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+			FirstName: "Pat", LastName: "Portal", Email: email, SelectedSeats: []int{seat},
+		}, header, &seats); status != http.StatusOK {
+			t.Fatalf("book seat %d: status %d", seat, status)
+		}
+		if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/conference/%d/book", conference.ID), ConferenceBooking{
+			FirstName: "Pat", LastName: "Portal", Email: email, Tickets: 1,
+		}, header, nil); status != http.StatusOK {
+			t.Fatalf("book conference: status %d", status)
+		}
+		ticket, err := newRepositories(db).Tickets.GetBusTicket(seats.TicketIDs[0])
+		if err != nil {
+			t.Fatalf("load ticket: %v", err)
+		}
+		return customer{header: header, busTicket: ticket.ID, busBooking: ticket.BusBookingID}
+	}
+	ann := book(signIn(t, db, "ann@example.com", roleCustomer), "ann@example.com", 1)
+	bob := book(signIn(t, db, "bob@example.com", roleCustomer), "bob@example.com", 2)
+	book(nil, "ann@example.com", 3)
+
+	type listing struct {
+		Bus struct {
+			Upcoming []struct {
+				Booking BusBooking `json:"booking"`
+			} `json:"upcoming"`
+		} `json:"bus"`
+		Conferences struct {
+			Upcoming []struct {
+				Booking ConferenceBooking `json:"booking"`
+			} `json:"upcoming"`
+		} `json:"conferences"`
+	}
+	for _, tc := range []struct {
+		name  string
+		who   customer
+		other customer
+		seat  int
+	}{
+		{"ann", ann, bob, 1},
+		{"bob", bob, ann, 2},
+	} {
+		var list listing
+		if status := doJSON(t, r, http.MethodGet, "/api/me/bookings", nil, tc.who.header, &list); status != http.StatusOK {
+			t.Fatalf("%s: status %d", tc.name, status)
+		}
+		if len(list.Bus.Upcoming) != 1 || list.Bus.Upcoming[0].Booking.SeatNumber != tc.seat || len(list.Conferences.Upcoming) != 1 {
+			t.Errorf("%s sees %+v", tc.name, list)
+		}
+		for _, c := range list.Conferences.Upcoming {
+			if c.Booking.UserID == nil || c.Booking.Email != tc.name+"@example.com" {
+				t.Errorf("%s sees conference booking %+v", tc.name, c.Booking)
+			}
+		}
+
+		// The other customer's tickets and bookings stay out of reach too
+		if status := doJSON(t, r, http.MethodGet, "/api/tickets/"+tc.other.busTicket+"/pdf", nil, tc.who.header, nil); status != http.StatusForbidden {
+			t.Errorf("%s downloads another customer's ticket: status %d", tc.name, status)
+		}
+		cancel := fmt.Sprintf("/api/bus/%d/bookings/%d/cancel", trip.ID, tc.other.busBooking)
+		if status := doJSON(t, r, http.MethodPost, cancel, nil, tc.who.header, nil); status != http.StatusForbidden {
+			t.Errorf("%s cancels another customer's booking: status %d", tc.name, status)
+		}
+	}
+	if status := doJSON(t, r, http.MethodGet, "/api/me/bookings", nil, nil, nil); status != http.StatusUnauthorized {
+		t.Errorf("signed out: status %d", status)
+	}
+}
+
+func TestConferenceBookingConcurrency(t *testing.T) {
+	db := openConcurrentDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+
+	conference := Conference{
+		Title:            "Stress Conf",
+		Description:      "Concurrent bookings",
+		TimeZone:         "UTC",
+		StartsAt:         time.Now().Add(72 * time.Hour),
+		EndsAt:           time.Now().Add(96 * time.Hour),
+		Location:         "Hall A",
+		TotalTickets:     25,
+		RemainingTickets: 25,
+		Currency:         "EUR",
+		Free:             true,
+	}
+	if err := db.Create(&conference).Error; err != nil {
+		t.Fatalf("create conference: %v", err)
+	}
+
+	// Ask for twice the tickets there are, two at a time
+	const clients = 25
+	path := fmt.Sprintf("/api/conference/%d/book", conference.ID)
+	var wg sync.WaitGroup
+	statuses := make(chan int, clients)
+	start := make(chan struct{})
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			statuses <- doJSON(t, r, http.MethodPost, path, ConferenceBooking{
+				FirstName: "Sam",
+				LastName:  fmt.Sprintf("Guest%d", i),
+				Email:     fmt.Sprintf("guest%d@example.com", i),
+				Tickets:   2,
+			}, nil, nil)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusOK && status != http.StatusBadRequest {
+			t.Errorf("booking failed with status %d", status)
+		}
+	}
+
+	var stored Conference
+	if err := db.First(&stored, conference.ID).Error; err != nil {
+		t.Fatalf("load conference: %v", err)
+	}
+	var booked int64
+	if err := db.Model(&ConferenceBooking{}).Where("conference_id = ?", conference.ID).
+		Select("COALESCE(SUM(tickets), 0)").Scan(&booked).Error; err != nil {
+		t.Fatalf("sum bookings: %v", err)
+	}
+	if stored.RemainingTickets < 0 {
+		t.Fatalf("remaining tickets = %d, want >= 0", stored.RemainingTickets)
+	}
+	if booked > int64(stored.TotalTickets) {
+		t.Fatalf("booked %d tickets of %d", booked, stored.TotalTickets)
+	}
+	if stored.RemainingTickets > 1 {
+		t.Fatalf("remaining tickets = %d, want the conference sold out", stored.RemainingTickets)
+	}
+	if booked+int64(stored.RemainingTickets) != int64(stored.TotalTickets) {
+		t.Fatalf("booked %d + remaining %d != total %d", booked, stored.RemainingTickets, stored.TotalTickets)
+	}
+	var tickets int64
+	db.Model(&ConferenceTicket{}).Where("conference_id = ?", conference.ID).Count(&tickets)
+	if tickets != booked {
+		t.Fatalf("issued %d tickets for %d booked", tickets, booked)
+	}
+}
+
+func TestGenerateScheduledTrips(t *testing.T) {
+	db := openConcurrentDB(t)
+	useTestDB(t, db)
+	repos := newRepositories(db)
+
+	// Europe/Berlin moves to summer time in the night to Sunday 29 March 2026, and
+	// Monday 30 March is a holiday
+	route := Route{Name: routeName("Hamburg", "Munich"), Origin: "Hamburg", Destination: "Munich", TimeZone: "Europe/Berlin",
+		Stops: routeStops("Hamburg", "Munich")}
+	if err := repos.Buses.CreateRoute(&route); err != nil {
+		t.Fatalf("create route: %v", err)
+	}
+	vehicle := Vehicle{Name: fmt.Sprintf("Schedule Coach %d", time.Now().UnixNano()), Capacity: 3}
+	if err := repos.Buses.CreateVehicle(&vehicle); err != nil {
+		t.Fatalf("create vehicle: %v", err)
+	}
+	if err := repos.Schedules.CreateHoliday(&Holiday{Date: "2026-03-30", Name: "Test holiday"}); err != nil {
+		t.Fatalf("create holiday: %v", err)
+	}
+	morning := Schedule{RouteID: route.ID, VehicleID: vehicle.ID, DepartureTime: "08:00", ArrivalTime: "14:00",
+		StartDate: "2026-03-27", EndDate: "2026-03-31", ExcludeHolidays: true, Currency: "EUR", Free: true}
+	night := Schedule{RouteID: route.ID, VehicleID: vehicle.ID, DepartureTime: "22:00", ArrivalTime: "06:00",
+		Weekdays: "sat,mon", StartDate: "2026-03-27", Currency: "EUR", Free: true}
+	for _, schedule := range []*Schedule{&morning, &night} {
+		if err := repos.Schedules.CreateSchedule(schedule); err != nil {
+			t.Fatalf("create schedule: %v", err)
+		}
+	}
+
+	now := time.Date(2026, 3, 26, 12, 0, 0, 0, time.UTC)
+	if created, err := generateScheduledTrips(repos.Schedules, now, 7); err != nil || created != 6 {
+		t.Fatalf("generated %d trips, %v; want 6", created, err)
+	}
+
+	// Generating again, from two instances at once, adds nothing
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if created, err := generateScheduledTrips(repos.Schedules, now, 7); err != nil || created != 0 {
+				t.Errorf("generating again created %d trips, %v", created, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var trips []Trip
+	if err := db.Where("schedule_id IN ?", []uint{morning.ID, night.ID}).Find(&trips).Error; err != nil {
+		t.Fatalf("load trips: %v", err)
+	}
+	if len(trips) != 6 {
+		t.Errorf("%d trips generated, want 6", len(trips))
+	}
+	generated := map[string]Trip{}
+	for _, trip := range trips {
+		generated[fmt.Sprintf("%d %s", *trip.ScheduleID, trip.DepartsAt.UTC().Format("2006-01-02 15:04"))] = trip
+	}
+	for _, tc := range []struct {
+		schedule *Schedule
+		departs  string // UTC
+		arrives  string // UTC
+	}{
+		{&morning, "2026-03-27 07:00", "2026-03-27 13:00"},
+		{&morning, "2026-03-28 07:00", "2026-03-28 13:00"},
+		{&morning, "2026-03-29 06:00", "2026-03-29 12:00"}, // summer time
+		{&morning, "2026-03-31 06:00", "2026-03-31 12:00"}, // not on the holiday, and not after the end date
+		{&night, "2026-03-28 21:00", "2026-03-29 04:00"},   // an hour shorter over the change
+		{&night, "2026-03-30 20:00", "2026-03-31 04:00"},   // holidays are not excluded
+	} {
+		trip, ok := generated[fmt.Sprintf("%d %s", tc.schedule.ID, tc.departs)]
+		if !ok || trip.ArrivesAt.UTC().Format("2006-01-02 15:04") != tc.arrives {
+			t.Errorf("schedule %d departing %s: got %+v, want a trip arriving %s", tc.schedule.ID, tc.departs, trip, tc.arrives)
+			continue
+		}
+		var seats int64
+		db.Model(&BusSeat{}).Where("trip_id = ?", trip.ID).Count(&seats)
+		if seats != 3 {
+			t.Errorf("schedule %d departing %s: %d seats, want 3", tc.schedule.ID, tc.departs, seats)
+		}
+	}
+}
@@ -0,0 +1,1505 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned schema change. Versions are applied in the order of
+// the migrations slice and must never be renumbered once released.
+type Migration struct {
+	Version string
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a migration that has been applied
+type SchemaMigration struct {
+	Version   string `gorm:"primaryKey;size:64"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// migrations lists every schema change in the order it is applied. Models in this
+// file are frozen copies of the schema at that version; never point a migration at
+// the live structs, or old migrations change whenever a model does.
+var migrations = []Migration{
+	{Version: "0001", Name: "initial_schema", Up: migrateInitialSchemaUp, Down: migrateInitialSchemaDown},
+	{Version: "0002", Name: "bus_seats_unique_seat", Up: migrateUniqueBusSeatUp, Down: migrateUniqueBusSeatDown},
+	{Version: "0003", Name: "temporal_columns", Up: migrateTemporalColumnsUp, Down: migrateTemporalColumnsDown},
+	{Version: "0004", Name: "routes_vehicles_trips", Up: migrateTripsUp, Down: migrateTripsDown},
+	{Version: "0005", Name: "schedules", Up: migrateSchedulesUp, Down: migrateSchedulesDown},
+	{Version: "0006", Name: "route_stops", Up: migrateRouteStopsUp, Down: migrateRouteStopsDown},
+	{Version: "0007", Name: "seat_layouts", Up: migrateSeatLayoutsUp, Down: migrateSeatLayoutsDown},
+	{Version: "0008", Name: "pricing", Up: migratePricingUp, Down: migratePricingDown},
+	{Version: "0009", Name: "conference_ticket_backfill", Up: migrateConferenceTicketBackfillUp, Down: migrateConferenceTicketBackfillDown},
+	{Version: "0010", Name: "schedule_pricing", Up: migrateSchedulePricingUp, Down: migrateSchedulePricingDown},
+	{Version: "0011", Name: "conference_ticket_version", Up: migrateTicketVersionUp, Down: migrateTicketVersionDown},
+	{Version: "0012", Name: "idempotency_lease", Up: migrateIdempotencyLeaseUp, Down: migrateIdempotencyLeaseDown},
+	{Version: "0013", Name: "outbox_claims", Up: migrateOutboxClaimsUp, Down: migrateOutboxClaimsDown},
+	{Version: "0014", Name: "conference_free", Up: migrateConferenceFreeUp, Down: migrateConferenceFreeDown},
+	{Version: "0015", Name: "temporal_columns_indexes", Up: migrateTemporalIndexesUp, Down: migrateTemporalIndexesDown},
+}
+
+// appliedMigrations returns the applied migrations keyed by version
+func appliedMigrations(conn *gorm.DB) (map[string]SchemaMigration, error) {
+	if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := conn.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[string]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// migrateUp applies every pending migration and returns the ones it ran. Each
+// migration runs in its own transaction; MySQL commits DDL implicitly, so a failed
+// step there may need manual cleanup before it is retried.
+func migrateUp(conn *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %s_%s: %w", m.Version, m.Name, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// migrateDown reverts the given number of most recently applied migrations
+func migrateDown(conn *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := conn.Transaction(func(tx *gorm.DB) error {
+			if err := keepRebuiltIndexes(tx, m.Down); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", m.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("migration %s_%s: %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// runMigrateCommand implements `bookings migrate up|down [steps]|status` and returns the exit code
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: bookings migrate up|down [steps]|status")
+		return 2
+	}
+
+	conn, err := openDatabase()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect to the database:", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		ran, err := migrateUp(conn)
+		for _, m := range ran {
+			fmt.Printf("applied  %s_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(ran) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "steps must be a positive number")
+				return 2
+			}
+		}
+		reverted, err := migrateDown(conn, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %s_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migrations to revert")
+		}
+	case "status":
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, m := range migrations {
+			if row, ok := applied[m.Version]; ok {
+				fmt.Printf("applied  %s_%s  %s\n", m.Version, m.Name, row.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("pending  %s_%s\n", m.Version, m.Name)
+			}
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// dropColumn drops a column of a model. SQLite drops a column by rebuilding the table,
+// which loses the indexes on it, so there the indexes on the other columns are created
+// again afterwards.
+func dropColumn(tx *gorm.DB, model interface{}, name string) error {
+	m := tx.Migrator()
+	if tx.Dialector.Name() != "sqlite" {
+		return m.DropColumn(model, name)
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	column := name
+	if field := stmt.Schema.LookUpField(name); field != nil {
+		column = field.DBName
+	}
+	var indexes []string
+	if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = ? AND tbl_name = ? AND sql IS NOT NULL",
+		"index", stmt.Table).Scan(&indexes).Error; err != nil {
+		return err
+	}
+
+	if err := m.DropColumn(model, name); err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if strings.Contains(index, "`"+column+"`") || strings.Contains(index, `"`+column+`"`) {
+			continue
+		}
+		if err := tx.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepRebuiltIndexes runs a migration step and, on SQLite, creates again the indexes it
+// lost by rebuilding a table whose indexed columns are all still there. Down steps
+// written before dropColumn existed drop columns with the migrator directly.
+func keepRebuiltIndexes(tx *gorm.DB, step func(tx *gorm.DB) error) error {
+	if tx.Dialector.Name() != "sqlite" {
+		return step(tx)
+	}
+	type sqliteObject struct {
+		Type     string
+		Name     string
+		TblName  string
+		Rootpage int
+		SQL      string
+	}
+	schema := func() (tables map[string]int, indexes map[string]sqliteObject, err error) {
+		var objects []sqliteObject
+		if err := tx.Raw("SELECT type, name, tbl_name, rootpage, sql FROM sqlite_master WHERE type IN (?, ?) AND sql IS NOT NULL",
+			"table", "index").Scan(&objects).Error; err != nil {
+			return nil, nil, err
+		}
+		tables, indexes = map[string]int{}, map[string]sqliteObject{}
+		for _, object := range objects {
+			if object.Type == "table" {
+				tables[object.Name] = object.Rootpage
+			} else {
+				indexes[object.Name] = object
+			}
+		}
+		return tables, indexes, nil
+	}
+
+	tablesBefore, indexesBefore, err := schema()
+	if err != nil {
+		return err
+	}
+	columns := map[string][]string{}
+	for name := range indexesBefore {
+		var indexed []string
+		if err := tx.Raw("SELECT name FROM pragma_index_info(?)", name).Scan(&indexed).Error; err != nil {
+			return err
+		}
+		columns[name] = indexed
+	}
+
+	if err := step(tx); err != nil {
+		return err
+	}
+
+	tablesAfter, indexesAfter, err := schema()
+	if err != nil {
+		return err
+	}
+	m := tx.Migrator()
+	for name, index := range indexesBefore {
+		rootpage, ok := tablesAfter[index.TblName]
+		if _, kept := indexesAfter[name]; kept || !ok || rootpage == tablesBefore[index.TblName] {
+			continue
+		}
+		restore := true
+		for _, column := range columns[name] {
+			restore = restore && m.HasColumn(index.TblName, column)
+		}
+		if !restore {
+			continue
+		}
+		if err := tx.Exec(index.SQL).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateInitialSchemaUp creates the schema as AutoMigrate left it, so databases created
+// before migrations existed are adopted without changes. Those databases may already
+// have the seat index and the columns that later migrations add, so those migrations
+// skip what exists.
+func migrateInitialSchemaUp(tx *gorm.DB) error {
+	type Bus struct {
+		gorm.Model
+		Name           string
+		Origin         string
+		Destination    string
+		Date           string
+		DepartureTime  string
+		ArrivalTime    string
+		TotalSeats     int
+		RemainingSeats int
+	}
+	type BusSeat struct {
+		gorm.Model
+		BusID         uint
+		SeatNumber    int
+		SeatStatus    string `gorm:"default:available"`
+		BookingID     uint
+		HoldToken     string `gorm:"index"`
+		HoldExpiresAt *time.Time
+	}
+	type BusBooking struct {
+		gorm.Model
+		FirstName   string
+		LastName    string
+		Email       string
+		SeatNumber  int
+		BusID       uint
+		BusName     string
+		BookingDate string
+		BookingTime string
+		Status      string `gorm:"default:confirmed"`
+		UserID      *uint  `gorm:"index"`
+	}
+	type Conference struct {
+		gorm.Model
+		Title            string
+		Description      string
+		StartDate        string
+		EndDate          string
+		Location         string
+		TotalTickets     int
+		RemainingTickets int
+	}
+	type ConferenceBooking struct {
+		gorm.Model
+		FirstName        string
+		LastName         string
+		Email            string
+		Tickets          int
+		ConferenceID     uint
+		ConferenceName   string
+		BookingDate      string
+		BookingTime      string
+		Status           string `gorm:"default:confirmed"`
+		CancelledTickets int
+		UserID           *uint `gorm:"index"`
+	}
+	type ConferenceCancellation struct {
+		gorm.Model
+		ConferenceBookingID uint
+		ConferenceID        uint
+		Tickets             int
+		Reason              string
+	}
+	type ConferenceTicket struct {
+		ID                  string `gorm:"primaryKey"`
+		ConferenceBookingID uint   `gorm:"index"`
+		ConferenceID        uint
+		AttendeeName        string
+		AttendeeEmail       string
+		Revoked             bool
+		CreatedAt           time.Time
+		UpdatedAt           time.Time
+	}
+	type BusTicket struct {
+		ID           string `gorm:"primaryKey"`
+		BusBookingID uint
+		BusID        uint
+		SeatNumber   int
+		FirstName    string
+		LastName     string
+		Email        string
+		Used         bool
+		UsedAt       *time.Time
+		CheckedInBy  string
+		Revoked      bool
+		CreatedAt    time.Time
+	}
+	type OutboxMessage struct {
+		gorm.Model
+		Kind          string
+		Reference     string
+		Recipient     string
+		Subject       string
+		Body          string `gorm:"type:text"`
+		Status        string `gorm:"default:pending;index"`
+		Attempts      int
+		NextAttemptAt time.Time `gorm:"index"`
+		LastError     string    `gorm:"type:text"`
+		SentAt        *time.Time
+	}
+	type User struct {
+		gorm.Model
+		Email        string `gorm:"uniqueIndex;size:191"`
+		PasswordHash string
+		FirstName    string
+		LastName     string
+		Role         string `gorm:"default:customer"`
+	}
+	type IdempotencyRecord struct {
+		IdempotencyKey string `gorm:"primaryKey;size:191"`
+		RequestHash    string `gorm:"size:64"`
+		Completed      bool
+		StatusCode     int
+		ResponseBody   string `gorm:"type:text"`
+		CreatedAt      time.Time
+		ExpiresAt      time.Time `gorm:"index"`
+	}
+
+	return tx.AutoMigrate(&BusBooking{}, &Bus{}, &BusSeat{}, &Conference{}, &ConferenceBooking{}, &BusTicket{},
+		&ConferenceCancellation{}, &OutboxMessage{}, &ConferenceTicket{}, &User{}, &IdempotencyRecord{})
+}
+
+func migrateInitialSchemaDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable("idempotency_records", "users", "conference_tickets", "outbox_messages",
+		"conference_cancellations", "bus_tickets", "conference_bookings", "conferences", "bus_seats", "buses", "bus_bookings")
+}
+
+// migrateUniqueBusSeatUp stops a bus from having two rows for the same seat. Existing
+// duplicates are removed first, keeping the row that carries a booking, or the oldest.
+func migrateUniqueBusSeatUp(tx *gorm.DB) error {
+	type BusSeat struct {
+		ID         uint
+		BusID      uint
+		SeatNumber int
+		BookingID  uint
+	}
+	if tx.Migrator().HasIndex("bus_seats", "idx_bus_seat") {
+		return nil
+	}
+
+	var duplicates []struct {
+		BusID      uint
+		SeatNumber int
+	}
+	if err := tx.Table("bus_seats").Select("bus_id, seat_number").
+		Group("bus_id, seat_number").Having("COUNT(*) > 1").Scan(&duplicates).Error; err != nil {
+		return err
+	}
+
+	for _, d := range duplicates {
+		var rows []BusSeat
+		if err := tx.Table("bus_seats").Where("bus_id = ? AND seat_number = ?", d.BusID, d.SeatNumber).
+			Order("id").Find(&rows).Error; err != nil {
+			return err
+		}
+
+		keep := rows[0]
+		booked := 0
+		for _, row := range rows {
+			if row.BookingID == 0 {
+				continue
+			}
+			booked++
+			if booked == 1 {
+				keep = row
+			}
+		}
+		if booked > 1 {
+			return fmt.Errorf("seat %d of bus %d is booked more than once, resolve it before migrating", d.SeatNumber, d.BusID)
+		}
+
+		if err := tx.Table("bus_seats").Where("bus_id = ? AND seat_number = ? AND id <> ?", d.BusID, d.SeatNumber, keep.ID).
+			Delete(&BusSeat{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Exec("CREATE UNIQUE INDEX idx_bus_seat ON bus_seats (bus_id, seat_number)").Error
+}
+
+func migrateUniqueBusSeatDown(tx *gorm.DB) error {
+	return tx.Migrator().DropIndex("bus_seats", "idx_bus_seat")
+}
+
+// migrateTemporalColumnsUp replaces the free-form date and time strings with timestamps.
+// Trip and conference strings are read in APP_TIMEZONE, booking times in the server's
+// local zone they were written in. Rows whose strings cannot be parsed fall back to
+// their creation time and are logged for review.
+func migrateTemporalColumnsUp(tx *gorm.DB) error {
+	type Bus struct {
+		ID            uint
+		CreatedAt     time.Time
+		Date          string
+		DepartureTime string
+		ArrivalTime   string
+		TimeZone      string
+		DepartsAt     time.Time
+		ArrivesAt     time.Time
+	}
+	type Conference struct {
+		ID        uint
+		CreatedAt time.Time
+		StartDate string
+		EndDate   string
+		TimeZone  string
+		StartsAt  time.Time
+		EndsAt    time.Time
+	}
+	type BusBooking struct {
+		ID          uint
+		CreatedAt   time.Time
+		BookingDate string
+		BookingTime string
+		BookedAt    time.Time
+	}
+	type ConferenceBooking struct {
+		ID          uint
+		CreatedAt   time.Time
+		BookingDate string
+		BookingTime string
+		BookedAt    time.Time
+	}
+
+	tz := defaultTimeZone()
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return err
+	}
+
+	m := tx.Migrator()
+	for _, column := range []string{"TimeZone", "DepartsAt", "ArrivesAt"} {
+		if err := m.AddColumn(&Bus{}, column); err != nil {
+			return err
+		}
+	}
+	var buses []Bus
+	if err := tx.Select("id, created_at, date, departure_time, arrival_time").Find(&buses).Error; err != nil {
+		return err
+	}
+	for _, b := range buses {
+		departsAt, arrivesAt, err := legacyTrip(b.Date, b.DepartureTime, b.ArrivalTime, loc)
+		if err != nil {
+			log.Printf("Bus %d: %v, using its creation time", b.ID, err)
+			departsAt, arrivesAt = b.CreatedAt, b.CreatedAt
+		}
+		if err := tx.Table("buses").Where("id = ?", b.ID).Updates(map[string]interface{}{
+			"time_zone":  tz,
+			"departs_at": departsAt.UTC(),
+			"arrives_at": arrivesAt.UTC(),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"Date", "DepartureTime", "ArrivalTime"} {
+		if err := m.DropColumn(&Bus{}, column); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range []string{"TimeZone", "StartsAt", "EndsAt"} {
+		if err := m.AddColumn(&Conference{}, column); err != nil {
+			return err
+		}
+	}
+	var conferences []Conference
+	if err := tx.Select("id, created_at, start_date, end_date").Find(&conferences).Error; err != nil {
+		return err
+	}
+	for _, c := range conferences {
+		startsAt, endsAt, err := legacyConferenceDates(c.StartDate, c.EndDate, loc)
+		if err != nil {
+			log.Printf("Conference %d: %v, using its creation time", c.ID, err)
+			startsAt, endsAt = c.CreatedAt, c.CreatedAt
+		}
+		if err := tx.Table("conferences").Where("id = ?", c.ID).Updates(map[string]interface{}{
+			"time_zone": tz,
+			"starts_at": startsAt.UTC(),
+			"ends_at":   endsAt.UTC(),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"StartDate", "EndDate"} {
+		if err := m.DropColumn(&Conference{}, column); err != nil {
+			return err
+		}
+	}
+
+	for _, model := range []interface{}{&BusBooking{}, &ConferenceBooking{}} {
+		if err := m.AddColumn(model, "BookedAt"); err != nil {
+			return err
+		}
+	}
+	var busBookings []BusBooking
+	if err := tx.Select("id, created_at, booking_date, booking_time").Find(&busBookings).Error; err != nil {
+		return err
+	}
+	for _, b := range busBookings {
+		bookedAt, ok := parseLegacyDateTime(b.BookingDate, b.BookingTime, time.Local)
+		if !ok {
+			bookedAt = b.CreatedAt
+		}
+		if err := tx.Table("bus_bookings").Where("id = ?", b.ID).Update("booked_at", bookedAt.UTC()).Error; err != nil {
+			return err
+		}
+	}
+	var conferenceBookings []ConferenceBooking
+	if err := tx.Select("id, created_at, booking_date, booking_time").Find(&conferenceBookings).Error; err != nil {
+		return err
+	}
+	for _, b := range conferenceBookings {
+		bookedAt, ok := parseLegacyDateTime(b.BookingDate, b.BookingTime, time.Local)
+		if !ok {
+			bookedAt = b.CreatedAt
+		}
+		if err := tx.Table("conference_bookings").Where("id = ?", b.ID).Update("booked_at", bookedAt.UTC()).Error; err != nil {
+			return err
+		}
+	}
+	for _, model := range []interface{}{&BusBooking{}, &ConferenceBooking{}} {
+		for _, column := range []string{"BookingDate", "BookingTime"} {
+			if err := m.DropColumn(model, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateTemporalColumnsDown restores the string columns, written in each row's time zone
+func migrateTemporalColumnsDown(tx *gorm.DB) error {
+	type Bus struct {
+		ID            uint
+		Date          string
+		DepartureTime string
+		ArrivalTime   string
+		TimeZone      string
+		DepartsAt     time.Time
+		ArrivesAt     time.Time
+	}
+	type Conference struct {
+		ID        uint
+		StartDate string
+		EndDate   string
+		TimeZone  string
+		StartsAt  time.Time
+		EndsAt    time.Time
+	}
+	type BusBooking struct {
+		ID          uint
+		BookingDate string
+		BookingTime string
+		BookedAt    time.Time
+	}
+	type ConferenceBooking struct {
+		ID          uint
+		BookingDate string
+		BookingTime string
+		BookedAt    time.Time
+	}
+
+	m := tx.Migrator()
+	for _, column := range []string{"Date", "DepartureTime", "ArrivalTime"} {
+		if err := m.AddColumn(&Bus{}, column); err != nil {
+			return err
+		}
+	}
+	var buses []Bus
+	if err := tx.Select("id, time_zone, departs_at, arrives_at").Find(&buses).Error; err != nil {
+		return err
+	}
+	for _, b := range buses {
+		loc := mustLocation(b.TimeZone)
+		if err := tx.Table("buses").Where("id = ?", b.ID).Updates(map[string]interface{}{
+			"date":           b.DepartsAt.In(loc).Format(legacyDateLayout),
+			"departure_time": b.DepartsAt.In(loc).Format(legacyClockLayout),
+			"arrival_time":   b.ArrivesAt.In(loc).Format(legacyClockLayout),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"TimeZone", "DepartsAt", "ArrivesAt"} {
+		if err := m.DropColumn(&Bus{}, column); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range []string{"StartDate", "EndDate"} {
+		if err := m.AddColumn(&Conference{}, column); err != nil {
+			return err
+		}
+	}
+	var conferences []Conference
+	if err := tx.Select("id, time_zone, starts_at, ends_at").Find(&conferences).Error; err != nil {
+		return err
+	}
+	for _, c := range conferences {
+		loc := mustLocation(c.TimeZone)
+		if err := tx.Table("conferences").Where("id = ?", c.ID).Updates(map[string]interface{}{
+			"start_date": c.StartsAt.In(loc).Format(legacyDateLayout),
+			"end_date":   legacyEndDate(c.EndsAt.In(loc)),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"TimeZone", "StartsAt", "EndsAt"} {
+		if err := m.DropColumn(&Conference{}, column); err != nil {
+			return err
+		}
+	}
+
+	for _, model := range []interface{}{&BusBooking{}, &ConferenceBooking{}} {
+		for _, column := range []string{"BookingDate", "BookingTime"} {
+			if err := m.AddColumn(model, column); err != nil {
+				return err
+			}
+		}
+	}
+	for _, table := range []string{"bus_bookings", "conference_bookings"} {
+		var rows []BusBooking
+		if err := tx.Table(table).Select("id, booked_at").Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, b := range rows {
+			if err := tx.Table(table).Where("id = ?", b.ID).Updates(map[string]interface{}{
+				"booking_date": b.BookedAt.Local().Format(legacyDateLayout),
+				"booking_time": b.BookedAt.Local().Format("15:04:05"),
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	for _, model := range []interface{}{&BusBooking{}, &ConferenceBooking{}} {
+		if err := m.DropColumn(model, "BookedAt"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTripsUp splits buses into the routes they run, the vehicles that run them and
+// the dated trips that seats are sold for. Each bus becomes the trip with the same id,
+// so existing seats, bookings and tickets keep pointing at the same departure.
+func migrateTripsUp(tx *gorm.DB) error {
+	type Bus struct {
+		gorm.Model
+		Name           string
+		Origin         string
+		Destination    string
+		TimeZone       string
+		DepartsAt      time.Time
+		ArrivesAt      time.Time
+		TotalSeats     int
+		RemainingSeats int
+	}
+	type Route struct {
+		gorm.Model
+		Name        string
+		Origin      string
+		Destination string
+		TimeZone    string
+	}
+	type Vehicle struct {
+		gorm.Model
+		Name         string
+		Registration string
+		Capacity     int
+	}
+	type Trip struct {
+		gorm.Model
+		RouteID        uint `gorm:"index"`
+		VehicleID      uint `gorm:"index"`
+		DepartsAt      time.Time
+		ArrivesAt      time.Time
+		TotalSeats     int
+		RemainingSeats int
+	}
+
+	if err := tx.AutoMigrate(&Route{}, &Vehicle{}, &Trip{}); err != nil {
+		return err
+	}
+
+	var buses []Bus
+	if err := tx.Unscoped().Order("id").Find(&buses).Error; err != nil {
+		return err
+	}
+	for _, b := range buses {
+		var route Route
+		if err := tx.Where(map[string]interface{}{
+			"origin":      b.Origin,
+			"destination": b.Destination,
+			"time_zone":   b.TimeZone,
+		}).Attrs(Route{Name: routeName(b.Origin, b.Destination)}).FirstOrCreate(&route).Error; err != nil {
+			return err
+		}
+		var vehicle Vehicle
+		if err := tx.Where(map[string]interface{}{
+			"name":     b.Name,
+			"capacity": b.TotalSeats,
+		}).FirstOrCreate(&vehicle).Error; err != nil {
+			return err
+		}
+		trip := Trip{
+			Model:          b.Model,
+			RouteID:        route.ID,
+			VehicleID:      vehicle.ID,
+			DepartsAt:      b.DepartsAt,
+			ArrivesAt:      b.ArrivesAt,
+			TotalSeats:     b.TotalSeats,
+			RemainingSeats: b.RemainingSeats,
+		}
+		if err := tx.Create(&trip).Error; err != nil {
+			return err
+		}
+	}
+	if err := resetSequence(tx, "trips"); err != nil {
+		return err
+	}
+
+	m := tx.Migrator()
+	for _, table := range []string{"bus_seats", "bus_bookings", "bus_tickets"} {
+		if err := m.RenameColumn(table, "bus_id", "trip_id"); err != nil {
+			return err
+		}
+	}
+	return m.DropTable("buses")
+}
+
+// migrateTripsDown turns every trip back into a bus named after its vehicle
+func migrateTripsDown(tx *gorm.DB) error {
+	type Bus struct {
+		gorm.Model
+		Name           string
+		Origin         string
+		Destination    string
+		TimeZone       string
+		DepartsAt      time.Time
+		ArrivesAt      time.Time
+		TotalSeats     int
+		RemainingSeats int
+	}
+	type tripRow struct {
+		gorm.Model
+		Name           string
+		Origin         string
+		Destination    string
+		TimeZone       string
+		DepartsAt      time.Time
+		ArrivesAt      time.Time
+		TotalSeats     int
+		RemainingSeats int
+	}
+
+	if err := tx.AutoMigrate(&Bus{}); err != nil {
+		return err
+	}
+
+	var trips []tripRow
+	if err := tx.Table("trips").Unscoped().
+		Select("trips.id, trips.created_at, trips.updated_at, trips.deleted_at, vehicles.name, routes.origin, " +
+			"routes.destination, routes.time_zone, trips.departs_at, trips.arrives_at, trips.total_seats, trips.remaining_seats").
+		Joins("LEFT JOIN routes ON routes.id = trips.route_id").
+		Joins("LEFT JOIN vehicles ON vehicles.id = trips.vehicle_id").
+		Order("trips.id").
+		Scan(&trips).Error; err != nil {
+		return err
+	}
+	for _, t := range trips {
+		bus := Bus(t)
+		if err := tx.Create(&bus).Error; err != nil {
+			return err
+		}
+	}
+	if err := resetSequence(tx, "buses"); err != nil {
+		return err
+	}
+
+	m := tx.Migrator()
+	for _, table := range []string{"bus_seats", "bus_bookings", "bus_tickets"} {
+		if err := m.RenameColumn(table, "trip_id", "bus_id"); err != nil {
+			return err
+		}
+	}
+	return m.DropTable("trips", "vehicles", "routes")
+}
+
+// resetSequence moves a Postgres id sequence past rows inserted with explicit ids.
+// MySQL and SQLite continue after the highest id on their own.
+func resetSequence(tx *gorm.DB, table string) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), COALESCE(MAX(id), 1)) FROM "+table, table).Error
+}
+
+// migrateSchedulesUp adds recurring schedules, the holiday calendar and the link from a
+// trip to the schedule that generated it. A schedule has at most one trip per departure.
+func migrateSchedulesUp(tx *gorm.DB) error {
+	type Schedule struct {
+		gorm.Model
+		RouteID         uint `gorm:"index"`
+		VehicleID       uint `gorm:"index"`
+		DepartureTime   string
+		ArrivalTime     string
+		Weekdays        string
+		StartDate       string
+		EndDate         string
+		ExcludeHolidays bool
+	}
+	type Holiday struct {
+		gorm.Model
+		Date string `gorm:"uniqueIndex;size:10"`
+		Name string
+	}
+	type Trip struct {
+		ScheduleID *uint     `gorm:"uniqueIndex:idx_trip_schedule"`
+		DepartsAt  time.Time `gorm:"uniqueIndex:idx_trip_schedule"`
+	}
+
+	if err := tx.AutoMigrate(&Schedule{}, &Holiday{}); err != nil {
+		return err
+	}
+	m := tx.Migrator()
+	if err := m.AddColumn(&Trip{}, "ScheduleID"); err != nil {
+		return err
+	}
+	return m.CreateIndex(&Trip{}, "idx_trip_schedule")
+}
+
+func migrateSchedulesDown(tx *gorm.DB) error {
+	type Trip struct {
+		ScheduleID *uint     `gorm:"uniqueIndex:idx_trip_schedule"`
+		DepartsAt  time.Time `gorm:"uniqueIndex:idx_trip_schedule"`
+	}
+
+	m := tx.Migrator()
+	if err := m.DropIndex(&Trip{}, "idx_trip_schedule"); err != nil {
+		return err
+	}
+	if err := m.DropColumn(&Trip{}, "ScheduleID"); err != nil {
+		return err
+	}
+	return m.DropTable("schedules", "holidays")
+}
+
+// migrateRouteStopsUp gives every route its stops and sells seats per segment between
+// them. Existing routes call only at their origin and destination, so their seats keep a
+// single segment and their bookings board at the first stop and alight at the last.
+func migrateRouteStopsUp(tx *gorm.DB) error {
+	type Route struct {
+		ID          uint
+		Origin      string
+		Destination string
+	}
+	type RouteStop struct {
+		gorm.Model
+		RouteID  uint `gorm:"uniqueIndex:idx_route_stop"`
+		Sequence int  `gorm:"uniqueIndex:idx_route_stop"`
+		Name     string
+	}
+	type BusSeat struct {
+		Segment int `gorm:"not null;default:0"`
+	}
+	type BusBooking struct {
+		BoardingStopID  uint
+		BoardingStop    string
+		AlightingStopID uint
+		AlightingStop   string
+	}
+	type trip struct {
+		ID      uint
+		RouteID uint
+	}
+
+	if err := tx.AutoMigrate(&RouteStop{}); err != nil {
+		return err
+	}
+
+	var routes []Route
+	if err := tx.Find(&routes).Error; err != nil {
+		return err
+	}
+	ends := make(map[uint][2]RouteStop, len(routes))
+	for _, r := range routes {
+		stops := [2]RouteStop{
+			{RouteID: r.ID, Sequence: 0, Name: r.Origin},
+			{RouteID: r.ID, Sequence: 1, Name: r.Destination},
+		}
+		for i := range stops {
+			if err := tx.Create(&stops[i]).Error; err != nil {
+				return err
+			}
+		}
+		ends[r.ID] = stops
+	}
+
+	m := tx.Migrator()
+	if err := m.DropIndex("bus_seats", "idx_bus_seat"); err != nil {
+		return err
+	}
+	if err := m.AddColumn(&BusSeat{}, "Segment"); err != nil {
+		return err
+	}
+	if err := tx.Exec("CREATE UNIQUE INDEX idx_bus_seat ON bus_seats (trip_id, seat_number, segment)").Error; err != nil {
+		return err
+	}
+
+	for _, column := range []string{"BoardingStopID", "BoardingStop", "AlightingStopID", "AlightingStop"} {
+		if err := m.AddColumn(&BusBooking{}, column); err != nil {
+			return err
+		}
+	}
+	var trips []trip
+	if err := tx.Table("trips").Select("id, route_id").Find(&trips).Error; err != nil {
+		return err
+	}
+	for _, t := range trips {
+		stops, ok := ends[t.RouteID]
+		if !ok {
+			continue
+		}
+		if err := tx.Table("bus_bookings").Where("trip_id = ?", t.ID).Updates(map[string]interface{}{
+			"boarding_stop_id":  stops[0].ID,
+			"boarding_stop":     stops[0].Name,
+			"alighting_stop_id": stops[1].ID,
+			"alighting_stop":    stops[1].Name,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRouteStopsDown goes back to one row per seat. A seat booked on any segment
+// stays booked, by the booking of its first booked segment; the other bookings of a
+// shared seat keep their tickets but no longer hold a seat row.
+func migrateRouteStopsDown(tx *gorm.DB) error {
+	type BusSeat struct {
+		TripID     uint
+		SeatNumber int
+		Segment    int `gorm:"not null;default:0"`
+		SeatStatus string
+		BookingID  uint
+	}
+	type BusBooking struct {
+		BoardingStopID  uint
+		BoardingStop    string
+		AlightingStopID uint
+		AlightingStop   string
+	}
+
+	var booked []BusSeat
+	if err := tx.Where("segment > ? AND seat_status = ?", 0, "booked").
+		Order("trip_id").Order("seat_number").Order("segment").Find(&booked).Error; err != nil {
+		return err
+	}
+	for _, s := range booked {
+		result := tx.Model(&BusSeat{}).
+			Where("trip_id = ? AND seat_number = ? AND segment = ? AND seat_status <> ?", s.TripID, s.SeatNumber, 0, "booked").
+			Updates(map[string]interface{}{"seat_status": "booked", "booking_id": s.BookingID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			log.Printf("Trip %d seat %d: booking %d shares the seat with an earlier booking", s.TripID, s.SeatNumber, s.BookingID)
+		}
+	}
+	if err := tx.Where("segment > ?", 0).Delete(&BusSeat{}).Error; err != nil {
+		return err
+	}
+
+	m := tx.Migrator()
+	if err := m.DropIndex("bus_seats", "idx_bus_seat"); err != nil {
+		return err
+	}
+	if err := m.DropColumn(&BusSeat{}, "Segment"); err != nil {
+		return err
+	}
+	if err := tx.Exec("CREATE UNIQUE INDEX idx_bus_seat ON bus_seats (trip_id, seat_number)").Error; err != nil {
+		return err
+	}
+
+	for _, column := range []string{"BoardingStopID", "BoardingStop", "AlightingStopID", "AlightingStop"} {
+		if err := m.DropColumn(&BusBooking{}, column); err != nil {
+			return err
+		}
+	}
+	return m.DropTable("route_stops")
+}
+
+// migrateSeatLayoutsUp adds seat layout templates and lets a vehicle be built to one.
+// Existing vehicles have no layout and keep the default seat map.
+func migrateSeatLayoutsUp(tx *gorm.DB) error {
+	type SeatLayout struct {
+		gorm.Model
+		Name    string `gorm:"uniqueIndex;size:100"`
+		Decks   int
+		Rows    int
+		Columns int
+		Aisles  string
+	}
+	type LayoutSeat struct {
+		ID             uint `gorm:"primaryKey"`
+		SeatLayoutID   uint `gorm:"uniqueIndex:idx_layout_seat"`
+		SeatNumber     int  `gorm:"uniqueIndex:idx_layout_seat"`
+		Deck           int
+		Row            int
+		Column         int
+		Position       string
+		Class          string
+		Accessible     bool
+		DriverAdjacent bool
+	}
+	type Vehicle struct {
+		SeatLayoutID *uint `gorm:"index"`
+	}
+
+	if err := tx.AutoMigrate(&SeatLayout{}, &LayoutSeat{}); err != nil {
+		return err
+	}
+	m := tx.Migrator()
+	if err := m.AddColumn(&Vehicle{}, "SeatLayoutID"); err != nil {
+		return err
+	}
+	return m.CreateIndex(&Vehicle{}, "SeatLayoutID")
+}
+
+func migrateSeatLayoutsDown(tx *gorm.DB) error {
+	type Vehicle struct {
+		SeatLayoutID *uint `gorm:"index"`
+	}
+
+	m := tx.Migrator()
+	if err := m.DropIndex(&Vehicle{}, "SeatLayoutID"); err != nil {
+		return err
+	}
+	if err := m.DropColumn(&Vehicle{}, "SeatLayoutID"); err != nil {
+		return err
+	}
+	return m.DropTable("layout_seats", "seat_layouts")
+}
+
+// pricingColumns are the columns the pricing migration adds to each table
+var pricingColumns = map[string][]string{
+	"trips":               {"Currency", "TaxBasisPoints"},
+	"conferences":         {"Currency", "TaxBasisPoints"},
+	"bus_bookings":        {"SeatClass", "Currency", "Price", "Tax", "Total"},
+	"conference_bookings": {"Tier", "Currency", "UnitPrice", "Subtotal", "Tax", "Total"},
+}
+
+// pricingModels are frozen copies of the priced tables at the pricing migration
+func pricingModels() map[string]interface{} {
+	type Trip struct {
+		Currency       string `gorm:"size:3"`
+		TaxBasisPoints int    `gorm:"not null;default:0"`
+	}
+	type Conference struct {
+		Currency       string `gorm:"size:3"`
+		TaxBasisPoints int    `gorm:"not null;default:0"`
+	}
+	type BusBooking struct {
+		SeatClass string
+		Currency  string `gorm:"size:3"`
+		Price     int64
+		Tax       int64
+		Total     int64
+	}
+	type ConferenceBooking struct {
+		Tier      string
+		Currency  string `gorm:"size:3"`
+		UnitPrice int64
+		Subtotal  int64
+		Tax       int64
+		Total     int64
+	}
+	return map[string]interface{}{
+		"trips":               &Trip{},
+		"conferences":         &Conference{},
+		"bus_bookings":        &BusBooking{},
+		"conference_bookings": &ConferenceBooking{},
+	}
+}
+
+// migratePricingUp adds fares per trip and seat class, ticket tiers per conference and
+// the price of every booking. Existing trips and conferences get the default currency
+// and no fares or tiers, so they stay free; existing bookings cost nothing.
+func migratePricingUp(tx *gorm.DB) error {
+	type TripFare struct {
+		ID        uint   `gorm:"primaryKey"`
+		TripID    uint   `gorm:"uniqueIndex:idx_trip_fare"`
+		SeatClass string `gorm:"uniqueIndex:idx_trip_fare;size:50"`
+		Price     int64
+	}
+	type TicketTier struct {
+		ID           uint   `gorm:"primaryKey"`
+		ConferenceID uint   `gorm:"uniqueIndex:idx_ticket_tier"`
+		Name         string `gorm:"uniqueIndex:idx_ticket_tier;size:50"`
+		Price        int64
+	}
+
+	if err := tx.AutoMigrate(&TripFare{}, &TicketTier{}); err != nil {
+		return err
+	}
+	m := tx.Migrator()
+	models := pricingModels()
+	for _, table := range []string{"trips", "conferences", "bus_bookings", "conference_bookings"} {
+		for _, column := range pricingColumns[table] {
+			if err := m.AddColumn(models[table], column); err != nil {
+				return err
+			}
+		}
+	}
+
+	currency := defaultCurrency()
+	for _, table := range []string{"trips", "conferences"} {
+		if err := tx.Exec("UPDATE "+table+" SET currency = ?", currency).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migratePricingDown(tx *gorm.DB) error {
+	m := tx.Migrator()
+	models := pricingModels()
+	for _, table := range []string{"trips", "conferences", "bus_bookings", "conference_bookings"} {
+		for _, column := range pricingColumns[table] {
+			if err := m.DropColumn(models[table], column); err != nil {
+				return err
+			}
+		}
+	}
+	return m.DropTable("ticket_tiers", "trip_fares")
+}
+
+// migrateConferenceTicketBackfillUp issues the per attendee tickets of conference
+// bookings made before tickets existed, so every ticket is downloaded by its UUID
+func migrateConferenceTicketBackfillUp(tx *gorm.DB) error {
+	type ConferenceBooking struct {
+		ID           uint
+		ConferenceID uint
+		Tickets      int
+		CreatedAt    time.Time
+	}
+	type ConferenceTicket struct {
+		ID                  string `gorm:"primaryKey"`
+		ConferenceBookingID uint
+		ConferenceID        uint
+		CreatedAt           time.Time
+		UpdatedAt           time.Time
+	}
+
+	var bookings []ConferenceBooking
+	if err := tx.Table("conference_bookings").
+		Where("deleted_at IS NULL AND tickets > 0").
+		Where("NOT EXISTS (SELECT 1 FROM conference_tickets t WHERE t.conference_booking_id = conference_bookings.id)").
+		Find(&bookings).Error; err != nil {
+		return err
+	}
+	for _, b := range bookings {
+		tickets := make([]ConferenceTicket, 0, b.Tickets)
+		for i := 0; i < b.Tickets; i++ {
+			tickets = append(tickets, ConferenceTicket{
+				ID:                  uuid.New().String(),
+				ConferenceBookingID: b.ID,
+				ConferenceID:        b.ConferenceID,
+				CreatedAt:           b.CreatedAt,
+				UpdatedAt:           b.CreatedAt,
+			})
+		}
+		if err := tx.Table("conference_tickets").Create(&tickets).Error; err != nil {
+			return err
+		}
+	}
+	if len(bookings) > 0 {
+		log.Printf("Issued tickets for %d conference booking(s) made before per attendee tickets", len(bookings))
+	}
+	return nil
+}
+
+// migrateConferenceTicketBackfillDown keeps the issued tickets; they were sent to
+// customers and are valid rows of the older schema too
+func migrateConferenceTicketBackfillDown(tx *gorm.DB) error {
+	return nil
+}
+
+// schedulePricingModels are frozen copies of the tables the schedule pricing migration changes
+func schedulePricingModels() (trip, schedule interface{}) {
+	type Trip struct {
+		Free bool `gorm:"not null;default:false"`
+	}
+	type Schedule struct {
+		Currency       string `gorm:"size:3"`
+		TaxBasisPoints int    `gorm:"not null;default:0"`
+		Free           bool   `gorm:"not null;default:false"`
+	}
+	return &Trip{}, &Schedule{}
+}
+
+// migrateSchedulePricingUp gives schedules the pricing of the trips they generate and
+// marks trips that may be booked without fares. Trips and schedules that exist without
+// fares were free until now and stay so.
+func migrateSchedulePricingUp(tx *gorm.DB) error {
+	type ScheduleFare struct {
+		ID         uint   `gorm:"primaryKey"`
+		ScheduleID uint   `gorm:"uniqueIndex:idx_schedule_fare"`
+		SeatClass  string `gorm:"uniqueIndex:idx_schedule_fare;size:50"`
+		Price      int64
+	}
+
+	if err := tx.AutoMigrate(&ScheduleFare{}); err != nil {
+		return err
+	}
+	m := tx.Migrator()
+	trip, schedule := schedulePricingModels()
+	if err := m.AddColumn(trip, "Free"); err != nil {
+		return err
+	}
+	for _, column := range []string{"Currency", "TaxBasisPoints", "Free"} {
+		if err := m.AddColumn(schedule, column); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Exec("UPDATE trips SET free = ? WHERE NOT EXISTS (SELECT 1 FROM trip_fares f WHERE f.trip_id = trips.id)", true).Error; err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE schedules SET currency = ?, free = ?", defaultCurrency(), true).Error
+}
+
+func migrateSchedulePricingDown(tx *gorm.DB) error {
+	m := tx.Migrator()
+	trip, schedule := schedulePricingModels()
+	for _, column := range []string{"Currency", "TaxBasisPoints", "Free"} {
+		if err := dropColumn(tx, schedule, column); err != nil {
+			return err
+		}
+	}
+	if err := dropColumn(tx, trip, "Free"); err != nil {
+		return err
+	}
+	return m.DropTable("schedule_fares")
+}
+
+// ticketVersionModel is the frozen conference ticket column the ticket version migration adds
+func ticketVersionModel() interface{} {
+	type ConferenceTicket struct {
+		Version int `gorm:"not null;default:0"`
+	}
+	return &ConferenceTicket{}
+}
+
+// migrateTicketVersionUp numbers the issues of each conference ticket. Tickets issued
+// so far carry no version in their token, which reads as 0, so they stay valid.
+func migrateTicketVersionUp(tx *gorm.DB) error {
+	m := tx.Migrator()
+	if m.HasColumn(ticketVersionModel(), "Version") {
+		return nil
+	}
+	return m.AddColumn(ticketVersionModel(), "Version")
+}
+
+func migrateTicketVersionDown(tx *gorm.DB) error {
+	return dropColumn(tx, ticketVersionModel(), "Version")
+}
+
+// idempotencyLeaseModel is the frozen idempotency record columns the lease migration adds
+func idempotencyLeaseModel() interface{} {
+	type IdempotencyRecord struct {
+		ClaimToken  string `gorm:"size:36"`
+		LockedUntil time.Time
+	}
+	return &IdempotencyRecord{}
+}
+
+// migrateIdempotencyLeaseUp lets a retry take over a key whose request stopped
+// without finishing. Records from before have no lease and can be taken over at once.
+func migrateIdempotencyLeaseUp(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, column := range []string{"ClaimToken", "LockedUntil"} {
+		if m.HasColumn(idempotencyLeaseModel(), column) {
+			continue
+		}
+		if err := m.AddColumn(idempotencyLeaseModel(), column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateIdempotencyLeaseDown(tx *gorm.DB) error {
+	for _, column := range []string{"LockedUntil", "ClaimToken"} {
+		if err := dropColumn(tx, idempotencyLeaseModel(), column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outboxClaimsModel is the frozen outbox columns the claims migration adds
+func outboxClaimsModel() interface{} {
+	type OutboxMessage struct {
+		ClaimedBy    string     `gorm:"size:36"`
+		ClaimedUntil *time.Time `gorm:"index"`
+	}
+	return &OutboxMessage{}
+}
+
+// migrateOutboxClaimsUp lets a delivery run hold a message under its own ID until a
+// lease runs out, instead of claiming it by comparing timestamps
+func migrateOutboxClaimsUp(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for _, column := range []string{"ClaimedBy", "ClaimedUntil"} {
+		if m.HasColumn(outboxClaimsModel(), column) {
+			continue
+		}
+		if err := m.AddColumn(outboxClaimsModel(), column); err != nil {
+			return err
+		}
+	}
+	if m.HasIndex(outboxClaimsModel(), "ClaimedUntil") {
+		return nil
+	}
+	return m.CreateIndex(outboxClaimsModel(), "ClaimedUntil")
+}
+
+func migrateOutboxClaimsDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropIndex(outboxClaimsModel(), "ClaimedUntil"); err != nil {
+		return err
+	}
+	for _, column := range []string{"ClaimedUntil", "ClaimedBy"} {
+		if err := dropColumn(tx, outboxClaimsModel(), column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conferenceFreeModel is the frozen conference column the conference free migration adds
+func conferenceFreeModel() interface{} {
+	type Conference struct {
+		Free bool `gorm:"not null;default:false"`
+	}
+	return &Conference{}
+}
+
+// migrateConferenceFreeUp marks conferences that may be booked without tiers.
+// Conferences that exist without tiers were free until now and stay so.
+func migrateConferenceFreeUp(tx *gorm.DB) error {
+	if err := tx.Migrator().AddColumn(conferenceFreeModel(), "Free"); err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE conferences SET free = ? WHERE NOT EXISTS (SELECT 1 FROM ticket_tiers t WHERE t.conference_id = conferences.id)", true).Error
+}
+
+func migrateConferenceFreeDown(tx *gorm.DB) error {
+	return dropColumn(tx, conferenceFreeModel(), "Free")
+}
+
+// temporalIndexModels are frozen copies of the indexes migration 0003 lost on SQLite,
+// where dropping the old date and time columns rebuilt their tables
+func temporalIndexModels() map[interface{}][]string {
+	type Conference struct {
+		DeletedAt gorm.DeletedAt `gorm:"index"`
+	}
+	type BusBooking struct {
+		DeletedAt gorm.DeletedAt `gorm:"index"`
+		UserID    *uint          `gorm:"index"`
+	}
+	type ConferenceBooking struct {
+		DeletedAt gorm.DeletedAt `gorm:"index"`
+		UserID    *uint          `gorm:"index"`
+	}
+	return map[interface{}][]string{
+		&Conference{}:        {"DeletedAt"},
+		&BusBooking{}:        {"DeletedAt", "UserID"},
+		&ConferenceBooking{}: {"DeletedAt", "UserID"},
+	}
+}
+
+// migrateTemporalIndexesUp creates the indexes migration 0003 lost on SQLite again.
+// Other databases kept them, so there it changes nothing.
+func migrateTemporalIndexesUp(tx *gorm.DB) error {
+	m := tx.Migrator()
+	for model, fields := range temporalIndexModels() {
+		for _, field := range fields {
+			if m.HasIndex(model, field) {
+				continue
+			}
+			if err := m.CreateIndex(model, field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateTemporalIndexesDown keeps the indexes; the tables had them before migration
+// 0003 as well
+func migrateTemporalIndexesDown(tx *gorm.DB) error {
+	return nil
+}
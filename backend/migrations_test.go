@@ -0,0 +1,146 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	indexes := func() []string {
+		var names []string
+		if err := db.Raw("SELECT name FROM sqlite_master WHERE type = ? AND sql IS NOT NULL ORDER BY name", "index").
+			Scan(&names).Error; err != nil {
+			t.Fatalf("list indexes: %v", err)
+		}
+		return names
+	}
+	before := indexes()
+
+	if _, err := migrateDown(db, len(migrations)); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	if after := indexes(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("indexes after a round trip:\n%v\nwant:\n%v", after, before)
+	}
+}
+
+// migrateBefore reverts the migrations from version on
+func migrateBefore(t *testing.T, db *gorm.DB, version string) {
+	t.Helper()
+	steps := 0
+	for i, m := range migrations {
+		if m.Version == version {
+			steps = len(migrations) - i
+		}
+	}
+	if _, err := migrateDown(db, steps); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+}
+
+func TestTemporalMigrationParsesLegacyTimes(t *testing.T) {
+	t.Setenv("APP_TIMEZONE", "Europe/Berlin")
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	// Go back to the schema with date and time strings
+	migrateBefore(t, db, "0003")
+	if err := db.Exec(`INSERT INTO buses (created_at, name, origin, destination, date, departure_time, arrival_time, total_seats, remaining_seats)
+		VALUES (?, 'Night Coach', 'Berlin', 'Vienna', '2025-03-01', '22:30', '06:15', 40, 40)`, time.Now()).Error; err != nil {
+		t.Fatalf("insert bus: %v", err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	var trip Trip
+	if err := db.First(&trip).Error; err != nil {
+		t.Fatalf("load trip: %v", err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	wantDeparts := time.Date(2025, 3, 1, 22, 30, 0, 0, berlin)
+	wantArrives := time.Date(2025, 3, 2, 6, 15, 0, 0, berlin)
+	if !trip.DepartsAt.Equal(wantDeparts) || !trip.ArrivesAt.Equal(wantArrives) {
+		t.Fatalf("trip runs %v to %v, want %v to %v", trip.DepartsAt, trip.ArrivesAt, wantDeparts, wantArrives)
+	}
+}
+
+func TestTripsMigrationKeepsBusIDs(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	migrateBefore(t, db, "0004")
+
+	// Two departures of the same line, one of them deleted, with ids that have gaps
+	departs := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	deleted := time.Now()
+	for _, stmt := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{`INSERT INTO buses (id, created_at, name, origin, destination, time_zone, departs_at, arrives_at, total_seats, remaining_seats)
+			VALUES (7, ?, 'Morning Coach', 'Lyon', 'Nice', 'UTC', ?, ?, 2, 1)`, []interface{}{departs, departs, departs.Add(4 * time.Hour)}},
+		{`INSERT INTO buses (id, created_at, deleted_at, name, origin, destination, time_zone, departs_at, arrives_at, total_seats, remaining_seats)
+			VALUES (12, ?, ?, 'Evening Coach', 'Lyon', 'Nice', 'UTC', ?, ?, 2, 2)`, []interface{}{departs, deleted, departs.Add(10 * time.Hour), departs.Add(14 * time.Hour)}},
+		{`INSERT INTO bus_seats (created_at, bus_id, seat_number, seat_status, booking_id) VALUES (?, 7, 1, 'booked', 3), (?, 7, 2, 'available', 0)`, []interface{}{departs, departs}},
+		{`INSERT INTO bus_bookings (id, created_at, first_name, last_name, email, seat_number, bus_id, status) VALUES (3, ?, 'Lea', 'Legacy', 'lea@example.com', 1, 7, 'confirmed')`, []interface{}{departs}},
+		{`INSERT INTO bus_tickets (id, bus_booking_id, bus_id, seat_number, created_at) VALUES ('legacy-ticket', 3, 7, 1, ?)`, []interface{}{departs}},
+	} {
+		if err := db.Exec(stmt.sql, stmt.args...).Error; err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	var trips []Trip
+	if err := db.Unscoped().Order("id").Find(&trips).Error; err != nil {
+		t.Fatalf("load trips: %v", err)
+	}
+	if len(trips) != 2 || trips[0].ID != 7 || trips[1].ID != 12 {
+		t.Fatalf("got trips %+v, want ids 7 and 12", trips)
+	}
+	if !trips[0].DepartsAt.Equal(departs) || trips[0].RemainingSeats != 1 || !trips[1].DeletedAt.Valid {
+		t.Errorf("trip 7 departs %v with %d seats left, trip 12 deleted %t", trips[0].DepartsAt, trips[0].RemainingSeats, trips[1].DeletedAt.Valid)
+	}
+	if trips[0].RouteID != trips[1].RouteID || trips[0].VehicleID == trips[1].VehicleID {
+		t.Errorf("trips use routes %d, %d and vehicles %d, %d; want one route and two vehicles",
+			trips[0].RouteID, trips[1].RouteID, trips[0].VehicleID, trips[1].VehicleID)
+	}
+
+	// The rows that pointed at bus 7 now point at trip 7
+	for _, tc := range []struct {
+		table string
+		where string
+	}{
+		{"bus_seats", "seat_number = 1"},
+		{"bus_bookings", "id = 3"},
+		{"bus_tickets", "id = 'legacy-ticket'"},
+	} {
+		var tripIDs []uint
+		if err := db.Table(tc.table).Where(tc.where).Pluck("trip_id", &tripIDs).Error; err != nil {
+			t.Fatalf("%s: %v", tc.table, err)
+		}
+		if len(tripIDs) == 0 {
+			t.Fatalf("%s: row lost", tc.table)
+		}
+		for _, id := range tripIDs {
+			if id != 7 {
+				t.Errorf("%s: trip_id %d, want 7", tc.table, id)
+			}
+		}
+	}
+
+	// New trips continue after the highest bus id
+	trip := createTestTrip(t, newRepositories(db), "After Migration", 2)
+	if trip.ID <= 12 {
+		t.Errorf("new trip got id %d, want one after 12", trip.ID)
+	}
+}
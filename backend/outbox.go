@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OutboxMessage is an email queued in the same transaction as the booking it belongs to
+type OutboxMessage struct {
+	gorm.Model
+	Kind          string     `json:"kind"`
+	Reference     string     `json:"reference"`
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	Body          string     `json:"body" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"default:pending;index"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" gorm:"index"`
+	LastError     string     `json:"lastError" gorm:"type:text"`
+	SentAt        *time.Time `json:"sentAt"`
+	ClaimedBy     string     `json:"-" gorm:"size:36"` // delivery run holding the message
+	ClaimedUntil  *time.Time `json:"-" gorm:"index"`   // the claim lapses after this, for runs that died
+}
+
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxLease       = 2 * time.Minute
+	outboxBatchSize   = 20
+)
+
+// outboxMaxAttempts returns how often a message is tried before it is dead-lettered
+func outboxMaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return 8
+}
+
+// enqueueMessage stores a message in the outbox using the caller's transaction
+func enqueueMessage(tx *gorm.DB, kind, reference string, msg Message) error {
+	return tx.Create(&OutboxMessage{
+		Kind:          kind,
+		Reference:     reference,
+		Recipient:     msg.To,
+		Subject:       msg.Subject,
+		Body:          msg.Body,
+		Status:        outboxPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// outboxBackoff doubles the delay for every failed attempt, up to outboxMaxBackoff
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return delay
+}
+
+// deliverOutbox sends the messages that are due and returns how many were processed
+func deliverOutbox(conn *gorm.DB) int {
+	repos := newRepositories(conn)
+	now := time.Now()
+	var due []OutboxMessage
+	if err := conn.Where("status = ? AND next_attempt_at <= ?", outboxPending, now).
+		Where("claimed_until IS NULL OR claimed_until < ?", now).
+		Order("next_attempt_at").Limit(outboxBatchSize).Find(&due).Error; err != nil {
+		log.Printf("Failed to load outbox: %v", err)
+		return 0
+	}
+
+	// Each run claims messages under its own ID and only settles the ones it holds
+	runID := uuid.New().String()
+	processed := 0
+	for _, msg := range due {
+		claimedUntil := time.Now().Add(outboxLease)
+		claim := conn.Model(&OutboxMessage{}).
+			Where("id = ? AND status = ?", msg.ID, outboxPending).
+			Where("claimed_until IS NULL OR claimed_until < ?", time.Now()).
+			Updates(map[string]interface{}{"claimed_by": runID, "claimed_until": claimedUntil})
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		held := func() *gorm.DB {
+			return conn.Model(&OutboxMessage{}).Where("id = ? AND claimed_by = ?", msg.ID, runID)
+		}
+
+		processed++
+		attempts := msg.Attempts + 1
+		body := msg.Body
+		attachments, err := messageAttachments(repos, msg.Kind, msg.Reference)
+		if err == nil && msg.Kind == "guest_link" {
+			body, err = guestLinkBody(msg.Recipient)
+		}
+		if err == nil {
+			err = mailer.Send(Message{To: msg.Recipient, Subject: msg.Subject, Body: body, Attachments: attachments})
+		}
+		if err == nil {
+			sentAt := time.Now()
+			held().Updates(map[string]interface{}{
+				"status":        outboxSent,
+				"attempts":      attempts,
+				"sent_at":       &sentAt,
+				"last_error":    "",
+				"claimed_by":    "",
+				"claimed_until": nil,
+			})
+			continue
+		}
+
+		updates := map[string]interface{}{
+			"attempts":        attempts,
+			"last_error":      err.Error(),
+			"next_attempt_at": time.Now().Add(outboxBackoff(attempts)),
+			"claimed_by":      "",
+			"claimed_until":   nil,
+		}
+		if attempts >= outboxMaxAttempts() {
+			updates["status"] = outboxDead
+			log.Printf("Outbox message %d dead-lettered after %d attempts: %v", msg.ID, attempts, err)
+		} else {
+			log.Printf("Outbox message %d failed (attempt %d): %v", msg.ID, attempts, err)
+		}
+		held().Updates(updates)
+	}
+	return processed
+}
+
+// startOutboxWorker delivers queued messages until the process exits
+func startOutboxWorker(conn *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deliverOutbox(conn)
+	}
+}
+
+// Handler to list outbox messages, dead-lettered ones by default
+func listOutboxMessages(conn *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := c.DefaultQuery("status", outboxDead)
+
+		var messages []OutboxMessage
+		if err := conn.Where("status = ?", status).Order("id desc").Find(&messages).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch outbox messages"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"messages": messages})
+	}
+}
+
+// Handler to put a failed outbox message back in the delivery queue
+func replayOutboxMessage(conn *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var msg OutboxMessage
+		if err := conn.First(&msg, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		if msg.Status == outboxSent {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Message already sent"})
+			return
+		}
+
+		if err := conn.Model(&msg).Updates(map[string]interface{}{
+			"status":          outboxPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+			"claimed_by":      "",
+			"claimed_until":   nil,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay message"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Message queued for delivery", "id": msg.ID})
+	}
+}
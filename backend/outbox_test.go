@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxSkipsClaimedMessages(t *testing.T) {
+	db := openMemoryDB(t)
+	sent := useTestDB(t, db)
+	if err := enqueueMessage(db, "notice", "1", Message{To: "ops@example.com", Subject: "Hello", Body: "Hi"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// Another run holds the message
+	until := time.Now().Add(time.Minute)
+	db.Model(&OutboxMessage{}).Where("1 = 1").Updates(map[string]interface{}{"claimed_by": "other-run", "claimed_until": until})
+	if processed := deliverOutbox(db); processed != 0 {
+		t.Fatalf("processed %d messages held by another run", processed)
+	}
+
+	// That run died; once its lease is over the message is delivered
+	db.Model(&OutboxMessage{}).Where("1 = 1").Update("claimed_until", time.Now().Add(-time.Second))
+	if processed := deliverOutbox(db); processed != 1 {
+		t.Fatalf("processed %d messages, want 1", processed)
+	}
+	if len(sent.Messages()) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent.Messages()))
+	}
+
+	var msg OutboxMessage
+	if err := db.First(&msg).Error; err != nil {
+		t.Fatalf("load message: %v", err)
+	}
+	if msg.Status != outboxSent || msg.ClaimedBy != "" || msg.ClaimedUntil != nil {
+		t.Fatalf("message after delivery: status %q, claimed by %q until %v", msg.Status, msg.ClaimedBy, msg.ClaimedUntil)
+	}
+}
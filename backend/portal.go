@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// customerBookings lists the bus and conference bookings of a customer, split into
+// upcoming and past trips and events. Cancel and ticket links start with apiPrefix so
+// guests get the routes authorized by their link.
+func customerBookings(apiPrefix string, bookings CustomerBookings) gin.H {
+	now := time.Now()
+
+	busUpcoming, busPast := []gin.H{}, []gin.H{}
+	for _, b := range bookings.Bus {
+		booking, trip := b.Booking, b.Trip
+		var ticketList []gin.H
+		for _, t := range b.Tickets {
+			ticketList = append(ticketList, gin.H{
+				"id":         t.ID,
+				"seatNumber": t.SeatNumber,
+				"used":       t.Used,
+				"revoked":    t.Revoked,
+				"pdfUrl":     apiPrefix + "/tickets/" + t.ID + "/pdf",
+			})
+		}
+
+		item := gin.H{
+			"booking": booking,
+			"bus":     trip,
+			"tickets": ticketList,
+		}
+		if !trip.DepartsAt.IsZero() && trip.DepartsAt.Before(now) {
+			busPast = append(busPast, item)
+			continue
+		}
+		if booking.Status != "cancelled" {
+			item["cancelUrl"] = fmt.Sprintf("%s/bus/%d/bookings/%d/cancel", apiPrefix, booking.TripID, booking.ID)
+		}
+		busUpcoming = append(busUpcoming, item)
+	}
+
+	confUpcoming, confPast := []gin.H{}, []gin.H{}
+	for _, b := range bookings.Conferences {
+		booking, conference := b.Booking, b.Conference
+		var ticketList []gin.H
+		for _, t := range b.Tickets {
+			ticketList = append(ticketList, gin.H{
+				"id":            t.ID,
+				"attendeeName":  t.AttendeeName,
+				"attendeeEmail": t.AttendeeEmail,
+				"revoked":       t.Revoked,
+				"pdfUrl":        apiPrefix + "/tickets/" + t.ID + "/pdf",
+			})
+		}
+
+		item := gin.H{
+			"booking":    booking,
+			"conference": conference,
+			"tickets":    ticketList,
+		}
+		if !conference.EndsAt.IsZero() && conference.EndsAt.Before(now) {
+			confPast = append(confPast, item)
+			continue
+		}
+		if booking.Status != "cancelled" {
+			item["cancelUrl"] = fmt.Sprintf("%s/conference/%d/bookings/%d/cancel", apiPrefix, booking.ConferenceID, booking.ID)
+		}
+		confUpcoming = append(confUpcoming, item)
+	}
+
+	return gin.H{
+		"bus": gin.H{
+			"upcoming": busUpcoming,
+			"past":     busPast,
+		},
+		"conferences": gin.H{
+			"upcoming": confUpcoming,
+			"past":     confPast,
+		},
+	}
+}
+
+// Handler to list the signed-in customer's bookings and tickets
+func getMyBookings(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, _ := currentUser(c)
+
+		list, err := bookings.UserBookings(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+			return
+		}
+		c.JSON(http.StatusOK, customerBookings("/api", list))
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Prices are whole numbers in the minor unit of their currency (cents for EUR or USD)
+// and exclude tax. Tax rates are in basis points, so 2000 is 20%; tax is added on top
+// of every quote line and rounded to the nearest minor unit.
+
+// TripFare is the price of a seat of one class for the whole route of a trip. Shorter
+// journeys pay the share of the fare for the segments they cover.
+type TripFare struct {
+	ID        uint   `json:"-" gorm:"primaryKey"`
+	TripID    uint   `json:"-" gorm:"uniqueIndex:idx_trip_fare"`
+	SeatClass string `json:"seatClass" gorm:"uniqueIndex:idx_trip_fare;size:50"`
+	Price     int64  `json:"price"`
+}
+
+// TicketTier is a price level of conference tickets, e.g. early bird or VIP. Tiers
+// share the tickets of the conference.
+type TicketTier struct {
+	ID           uint   `json:"-" gorm:"primaryKey"`
+	ConferenceID uint   `json:"-" gorm:"uniqueIndex:idx_ticket_tier"`
+	Name         string `json:"name" gorm:"uniqueIndex:idx_ticket_tier;size:50"`
+	Price        int64  `json:"price"`
+}
+
+// QuoteLine is one priced item of a quote
+type QuoteLine struct {
+	Description string `json:"description"`
+	SeatNumber  int    `json:"seatNumber,omitempty"`
+	SeatClass   string `json:"seatClass,omitempty"`
+	Tier        string `json:"tier,omitempty"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int64  `json:"unitPrice"`
+	Subtotal    int64  `json:"subtotal"`
+	Tax         int64  `json:"tax"`
+	Total       int64  `json:"total"`
+}
+
+// Quote is the itemized price of a booking
+type Quote struct {
+	Currency       string      `json:"currency"`
+	TaxBasisPoints int         `json:"taxBasisPoints"`
+	Lines          []QuoteLine `json:"lines"`
+	Subtotal       int64       `json:"subtotal"`
+	Tax            int64       `json:"tax"`
+	Total          int64       `json:"total"`
+}
+
+// BusQuoteRequest is the body of POST /api/bus/:id/quote
+type BusQuoteRequest struct {
+	SelectedSeats   []int `json:"selectedSeats"`
+	BoardingStopID  uint  `json:"boardingStopId"`
+	AlightingStopID uint  `json:"alightingStopId"`
+}
+
+// ConferenceQuoteRequest is the body of POST /api/conference/:id/quote
+type ConferenceQuoteRequest struct {
+	Tickets int    `json:"tickets"`
+	Tier    string `json:"tier"`
+}
+
+// TripPricingRequest is the body of POST /api/trips/:id/pricing
+type TripPricingRequest struct {
+	Currency       string     `json:"currency"`
+	TaxBasisPoints int        `json:"taxBasisPoints"`
+	Fares          []TripFare `json:"fares"`
+	Free           bool       `json:"free"`
+}
+
+// SchedulePricingRequest is the body of POST /api/schedules/:id/pricing
+type SchedulePricingRequest struct {
+	Currency       string         `json:"currency"`
+	TaxBasisPoints int            `json:"taxBasisPoints"`
+	Fares          []ScheduleFare `json:"fares"`
+	Free           bool           `json:"free"`
+}
+
+// ConferencePricingRequest is the body of POST /api/conference/:id/pricing
+type ConferencePricingRequest struct {
+	Currency       string       `json:"currency"`
+	TaxBasisPoints int          `json:"taxBasisPoints"`
+	Tiers          []TicketTier `json:"tiers"`
+	Free           bool         `json:"free"`
+}
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// defaultCurrency is the currency of trips and conferences created without one, set
+// with APP_CURRENCY (default EUR)
+func defaultCurrency() string {
+	if currency := os.Getenv("APP_CURRENCY"); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return "EUR"
+}
+
+// normalizePricing upper-cases the currency, filling in the default, and checks it and
+// the tax rate
+func normalizePricing(currency string, taxBasisPoints int) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = defaultCurrency()
+	}
+	if !currencyCode.MatchString(currency) {
+		return "", fmt.Errorf("currency must be a three-letter code like EUR")
+	}
+	if taxBasisPoints < 0 || taxBasisPoints > 10000 {
+		return "", errors.New("taxBasisPoints must be between 0 and 10000")
+	}
+	return currency, nil
+}
+
+// normalizeFares lower-cases the seat classes, standard when empty, and rejects
+// negative prices and classes priced twice
+func normalizeFares(fares []TripFare) ([]TripFare, error) {
+	seen := map[string]bool{}
+	result := make([]TripFare, 0, len(fares))
+	for _, fare := range fares {
+		class := strings.ToLower(strings.TrimSpace(fare.SeatClass))
+		if class == "" {
+			class = defaultSeatClass
+		}
+		if fare.Price < 0 {
+			return nil, fmt.Errorf("the %s fare cannot be negative", class)
+		}
+		if seen[class] {
+			return nil, fmt.Errorf("the %s fare is given twice", class)
+		}
+		seen[class] = true
+		result = append(result, TripFare{SeatClass: class, Price: fare.Price})
+	}
+	return result, nil
+}
+
+// normalizeTiers trims the tier names and rejects unnamed tiers, negative prices and
+// names given twice
+func normalizeTiers(tiers []TicketTier) ([]TicketTier, error) {
+	seen := map[string]bool{}
+	result := make([]TicketTier, 0, len(tiers))
+	for _, tier := range tiers {
+		name := strings.TrimSpace(tier.Name)
+		if name == "" {
+			return nil, errors.New("every ticket tier needs a name")
+		}
+		if tier.Price < 0 {
+			return nil, fmt.Errorf("the %s price cannot be negative", name)
+		}
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("the %s tier is given twice", name)
+		}
+		seen[strings.ToLower(name)] = true
+		result = append(result, TicketTier{Name: name, Price: tier.Price})
+	}
+	return result, nil
+}
+
+// taxOn returns the tax on an amount, rounded half up to the minor unit
+func taxOn(amount int64, taxBasisPoints int) int64 {
+	return (amount*int64(taxBasisPoints) + 5000) / 10000
+}
+
+// add prices a line from its unit price and quantity and adds it to the totals
+func (q *Quote) add(line QuoteLine) {
+	line.Subtotal = line.UnitPrice * int64(line.Quantity)
+	line.Tax = taxOn(line.Subtotal, q.TaxBasisPoints)
+	line.Total = line.Subtotal + line.Tax
+	q.Lines = append(q.Lines, line)
+	q.Subtotal += line.Subtotal
+	q.Tax += line.Tax
+	q.Total += line.Total
+}
+
+// currencyExponents are the ISO 4217 currencies whose minor unit is not a hundredth
+// of the major unit
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0,
+	"XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// currencyExponent returns the number of decimals of a currency's minor unit
+func currencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// formatMoney writes an amount in minor units with its currency, e.g. EUR 12.50,
+// JPY 1250 or KWD 12.500
+func formatMoney(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	exponent := currencyExponent(currency)
+	if exponent == 0 {
+		return fmt.Sprintf("%s %s%d", currency, sign, amount)
+	}
+	unit := int64(1)
+	for i := 0; i < exponent; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s %s%d.%0*d", currency, sign, amount/unit, exponent, amount%unit)
+}
+
+// tripFare returns the fare of a seat class on a trip, falling back to the standard
+// fare. Trips without fares can only be booked when they are marked free.
+func tripFare(trip Trip, class string) (int64, error) {
+	if len(trip.Fares) == 0 {
+		if trip.Free {
+			return 0, nil
+		}
+		return 0, errors.New("this trip has no fares yet")
+	}
+	var standard *TripFare
+	for i, fare := range trip.Fares {
+		if fare.SeatClass == class {
+			return fare.Price, nil
+		}
+		if fare.SeatClass == defaultSeatClass {
+			standard = &trip.Fares[i]
+		}
+	}
+	if standard == nil {
+		return 0, fmt.Errorf("there is no fare for %s seats on this trip", class)
+	}
+	return standard.Price, nil
+}
+
+// busQuote prices the seats of a journey on a trip whose route stops and fares are
+// loaded, using the seat classes of the trip's layout
+func busQuote(trip Trip, journey Journey, seatNumbers []int, layout SeatLayout) (Quote, error) {
+	classes := make(map[int]string, len(layout.Seats))
+	for _, seat := range layout.Seats {
+		classes[seat.SeatNumber] = seat.Class
+	}
+	segments := int64(segmentCount(trip.Route.Stops))
+	travelled := int64(journey.To - journey.From)
+
+	quote := Quote{Currency: trip.Currency, TaxBasisPoints: trip.TaxBasisPoints, Lines: []QuoteLine{}}
+	for _, seatNum := range seatNumbers {
+		class := classes[seatNum]
+		if class == "" {
+			class = defaultSeatClass
+		}
+		fare, err := tripFare(trip, class)
+		if err != nil {
+			return Quote{}, err
+		}
+		quote.add(QuoteLine{
+			Description: fmt.Sprintf("Seat %d (%s), %s to %s", seatNum, class, journey.Boarding.Name, journey.Alighting.Name),
+			SeatNumber:  seatNum,
+			SeatClass:   class,
+			Quantity:    1,
+			UnitPrice:   (fare*travelled*2 + segments) / (segments * 2),
+		})
+	}
+	return quote, nil
+}
+
+// conferenceQuote prices tickets of a tier of a conference whose tiers are loaded. The
+// tier may be left out when the conference has a single one. Conferences without tiers
+// can only be booked when they are marked free.
+func conferenceQuote(conference Conference, tierName string, tickets int) (Quote, error) {
+	quote := Quote{Currency: conference.Currency, TaxBasisPoints: conference.TaxBasisPoints, Lines: []QuoteLine{}}
+	tierName = strings.TrimSpace(tierName)
+	if len(conference.Tiers) == 0 {
+		if !conference.Free {
+			return Quote{}, errors.New("this conference has no ticket tiers yet")
+		}
+		if tierName != "" {
+			return Quote{}, errors.New("this conference has no ticket tiers")
+		}
+		quote.add(QuoteLine{Description: "Ticket for " + conference.Title, Quantity: tickets})
+		return quote, nil
+	}
+
+	if tierName == "" && len(conference.Tiers) == 1 {
+		tierName = conference.Tiers[0].Name
+	}
+	var names []string
+	for _, tier := range conference.Tiers {
+		if strings.EqualFold(tier.Name, tierName) {
+			quote.add(QuoteLine{
+				Description: fmt.Sprintf("%s ticket for %s", tier.Name, conference.Title),
+				Tier:        tier.Name,
+				Quantity:    tickets,
+				UnitPrice:   tier.Price,
+			})
+			return quote, nil
+		}
+		names = append(names, tier.Name)
+	}
+	return Quote{}, fmt.Errorf("tier must be one of %s", strings.Join(names, ", "))
+}
+
+// Handler to price seats on a bus trip without booking them
+func quoteBusHandler(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+		var req BusQuoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if len(req.SelectedSeats) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No seat selected"})
+			return
+		}
+
+		trip, err := buses.GetTrip(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Bus not found"})
+			return
+		}
+		journey, err := tripJourney(trip, req.BoardingStopID, req.AlightingStopID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		seatNumbers := uniqueSeats(req.SelectedSeats)
+		for _, seatNum := range seatNumbers {
+			if seatNum < 1 || seatNum > trip.TotalSeats {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Seat %d not found", seatNum)})
+				return
+			}
+		}
+		layout, err := buses.TripSeatLayout(trip)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seat layout"})
+			return
+		}
+
+		quote, err := busQuote(trip, journey, seatNumbers, layout)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"journey": journey, "quote": quote})
+	}
+}
+
+// Handler to price conference tickets without booking them
+func quoteConferenceHandler(conferences ConferenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
+			return
+		}
+		var req ConferenceQuoteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if req.Tickets < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid number of tickets"})
+			return
+		}
+
+		conference, err := conferences.GetConference(id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
+			return
+		}
+		quote, err := conferenceQuote(conference, req.Tier, req.Tickets)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"quote": quote, "remaining": conference.RemainingTickets})
+	}
+}
+
+// Handler to replace the currency, tax rate and fares of a trip, and whether it is free
+// without fares. Bookings already made keep the price they were quoted.
+func setTripPricing(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+			return
+		}
+		var req TripPricingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		currency, err := normalizePricing(req.Currency, req.TaxBasisPoints)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		fares, err := normalizeFares(req.Fares)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := buses.GetTrip(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trip not found"})
+			return
+		}
+		if err := buses.SetTripPricing(id, currency, req.TaxBasisPoints, req.Free, fares); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trip pricing"})
+			return
+		}
+		trip, err := buses.GetTrip(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trip"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"trip": trip})
+	}
+}
+
+// Handler to replace the currency, tax rate and ticket tiers of a conference, and whether
+// it is free without tiers. Bookings already made keep the price they were quoted.
+func setConferencePricing(conferences ConferenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
+			return
+		}
+		var req ConferencePricingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		currency, err := normalizePricing(req.Currency, req.TaxBasisPoints)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		tiers, err := normalizeTiers(req.Tiers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := conferences.GetConference(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conference not found"})
+			return
+		}
+		if err := conferences.SetConferencePricing(id, currency, req.TaxBasisPoints, req.Free, tiers); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conference pricing"})
+			return
+		}
+		conference, err := conferences.GetConference(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conference"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"conference": conference})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestScheduledTripCopiesPricing(t *testing.T) {
+	schedule := Schedule{
+		DepartureTime:  "08:00",
+		ArrivalTime:    "10:30",
+		StartDate:      "2026-01-01",
+		Vehicle:        Vehicle{Capacity: 40},
+		Currency:       "USD",
+		TaxBasisPoints: 800,
+		Fares:          []ScheduleFare{{SeatClass: "standard", Price: 1500}, {SeatClass: "premium", Price: 2500}},
+	}
+	schedule.ID = 7
+
+	trip, ok := scheduledTrip(schedule, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), nil)
+	if !ok {
+		t.Fatal("schedule does not run on a day it should")
+	}
+	if trip.Currency != "USD" || trip.TaxBasisPoints != 800 || trip.Free {
+		t.Errorf("got currency %s, tax %d, free %t", trip.Currency, trip.TaxBasisPoints, trip.Free)
+	}
+	if len(trip.Fares) != 2 || trip.Fares[0].Price != 1500 || trip.Fares[1].SeatClass != "premium" {
+		t.Errorf("got fares %+v", trip.Fares)
+	}
+}
+
+func TestBookingUnpricedTrip(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	repos := newRepositories(db)
+
+	unpriced := createTestTrip(t, repos, "Unpriced Coach", 4)
+	if err := repos.Buses.SetTripPricing(unpriced.ID, "EUR", 0, false, nil); err != nil {
+		t.Fatalf("clear fares: %v", err)
+	}
+	free := createTestTrip(t, repos, "Free Coach", 4)
+	if err := repos.Buses.SetTripPricing(free.ID, "EUR", 0, true, nil); err != nil {
+		t.Fatalf("clear fares: %v", err)
+	}
+
+	book := func(trip Trip) int {
+		return doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+			FirstName: "Una", LastName: "Priced", Email: "una@example.com", SelectedSeats: []int{1},
+		}, nil, nil)
+	}
+	if status := book(unpriced); status != http.StatusBadRequest {
+		t.Errorf("trip without fares: status %d, want 400", status)
+	}
+	if status := book(free); status != http.StatusOK {
+		t.Errorf("free trip: status %d, want 200", status)
+	}
+}
+
+func TestBookingUnpricedConference(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	repos := newRepositories(db)
+
+	create := func(title string, free bool) Conference {
+		conference := Conference{Title: title, Location: "Hall D", TimeZone: "UTC", TotalTickets: 10, RemainingTickets: 10,
+			StartsAt: time.Now().Add(24 * time.Hour), EndsAt: time.Now().Add(48 * time.Hour), Currency: "EUR", Free: free}
+		if err := repos.Conferences.CreateConference(&conference); err != nil {
+			t.Fatalf("create conference: %v", err)
+		}
+		return conference
+	}
+	unpriced, free := create("Unpriced Conf", false), create("Free Conf", true)
+
+	book := func(conference Conference) int {
+		return doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/conference/%d/book", conference.ID), ConferenceBooking{
+			FirstName: "Una", LastName: "Priced", Email: "una@example.com", Tickets: 1,
+		}, nil, nil)
+	}
+	if status := book(unpriced); status != http.StatusBadRequest {
+		t.Errorf("conference without tiers: status %d, want 400", status)
+	}
+	if status := book(free); status != http.StatusOK {
+		t.Errorf("free conference: status %d, want 200", status)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	for _, tc := range []struct {
+		amount   int64
+		currency string
+		want     string
+	}{
+		{1250, "EUR", "EUR 12.50"},
+		{5, "USD", "USD 0.05"},
+		{1250, "JPY", "JPY 1250"},
+		{12500, "KWD", "KWD 12.500"},
+		{7, "BHD", "BHD 0.007"},
+		{-1250, "EUR", "EUR -12.50"},
+	} {
+		if got := formatMoney(tc.amount, tc.currency); got != tc.want {
+			t.Errorf("formatMoney(%d, %s) = %q, want %q", tc.amount, tc.currency, got, tc.want)
+		}
+	}
+}
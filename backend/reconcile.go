@@ -0,0 +1,301 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Drift is a denormalized counter or ticket that disagrees with the rows it is derived from
+type Drift struct {
+	Kind     string `json:"kind"`
+	EntityID string `json:"entityId"`
+	Expected int    `json:"expected"`
+	Actual   int    `json:"actual"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// reconcileInventory compares the inventory counters with the underlying rows and,
+// when repair is set, fixes what it finds in a single transaction
+func reconcileInventory(conn *gorm.DB, repair bool) ([]Drift, error) {
+	drifts := []Drift{}
+
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		busDrifts, err := reconcileBusSeats(tx, repair)
+		if err != nil {
+			return err
+		}
+		conferenceDrifts, err := reconcileConferenceTickets(tx, repair)
+		if err != nil {
+			return err
+		}
+		ticketDrifts, err := reconcileBusTickets(tx, repair)
+		if err != nil {
+			return err
+		}
+		drifts = append(drifts, busDrifts...)
+		drifts = append(drifts, conferenceDrifts...)
+		drifts = append(drifts, ticketDrifts...)
+		return nil
+	})
+	return drifts, err
+}
+
+// reconcileBusSeats checks Trip.RemainingSeats against the seats that are not booked on
+// any segment of the trip
+func reconcileBusSeats(tx *gorm.DB, repair bool) ([]Drift, error) {
+	var trips []Trip
+	if err := tx.Preload("Vehicle").Find(&trips).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		TripID uint
+		Seats  int
+		Booked int
+	}
+	if err := tx.Model(&BusSeat{}).
+		Select("trip_id, COUNT(DISTINCT seat_number) AS seats, "+
+			"COUNT(DISTINCT CASE WHEN seat_status = ? THEN seat_number END) AS booked", "booked").
+		Group("trip_id").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	free := make(map[uint]int, len(counts))
+	for _, c := range counts {
+		free[c.TripID] = c.Seats - c.Booked
+	}
+
+	var drifts []Drift
+	for _, trip := range trips {
+		expected := free[trip.ID]
+		if trip.RemainingSeats == expected {
+			continue
+		}
+		d := Drift{
+			Kind:     "bus_remaining_seats",
+			EntityID: strconv.FormatUint(uint64(trip.ID), 10),
+			Expected: expected,
+			Actual:   trip.RemainingSeats,
+			Detail:   trip.Vehicle.Name,
+		}
+		if repair {
+			// Recount in the UPDATE itself rather than writing the count read above, so a
+			// booking that committed in between is not overwritten
+			free := tx.Model(&BusSeat{}).
+				Select("COUNT(DISTINCT seat_number) - COUNT(DISTINCT CASE WHEN seat_status = ? THEN seat_number END)", "booked").
+				Where("bus_seats.trip_id = trips.id")
+			if err := tx.Model(&Trip{}).Where("id = ?", trip.ID).
+				Update("remaining_seats", gorm.Expr("(?)", free)).Error; err != nil {
+				return nil, err
+			}
+			d.Repaired = true
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, nil
+}
+
+// reconcileConferenceTickets checks Conference.RemainingTickets against TotalTickets minus booked tickets
+func reconcileConferenceTickets(tx *gorm.DB, repair bool) ([]Drift, error) {
+	var conferences []Conference
+	if err := tx.Find(&conferences).Error; err != nil {
+		return nil, err
+	}
+
+	var sums []struct {
+		ConferenceID uint
+		Booked       int
+	}
+	// Bookings keep their net ticket count, so cancelled tickets are already excluded
+	if err := tx.Model(&ConferenceBooking{}).Select("conference_id, COALESCE(SUM(tickets), 0) AS booked").
+		Group("conference_id").Scan(&sums).Error; err != nil {
+		return nil, err
+	}
+	booked := make(map[uint]int, len(sums))
+	for _, s := range sums {
+		booked[s.ConferenceID] = s.Booked
+	}
+
+	var drifts []Drift
+	for _, conference := range conferences {
+		expected := conference.TotalTickets - booked[conference.ID]
+		if conference.RemainingTickets == expected {
+			continue
+		}
+		d := Drift{
+			Kind:     "conference_remaining_tickets",
+			EntityID: strconv.FormatUint(uint64(conference.ID), 10),
+			Expected: expected,
+			Actual:   conference.RemainingTickets,
+			Detail:   conference.Title,
+		}
+		if expected < 0 {
+			d.Detail = conference.Title + ": oversold, needs manual review"
+		} else if repair {
+			// Recount in the UPDATE itself, and leave a conference that got oversold since
+			// the read above for manual review
+			booked := tx.Model(&ConferenceBooking{}).Select("COALESCE(SUM(tickets), 0)").
+				Where("conference_bookings.conference_id = conferences.id")
+			result := tx.Model(&Conference{}).Where("id = ? AND total_tickets >= (?)", conference.ID, booked).
+				Update("remaining_tickets", gorm.Expr("total_tickets - (?)", booked))
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			d.Repaired = result.RowsAffected > 0
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, nil
+}
+
+// reconcileBusTickets checks that every active booking has one valid ticket, and that
+// tickets of cancelled or missing bookings are revoked
+func reconcileBusTickets(tx *gorm.DB, repair bool) ([]Drift, error) {
+	var bookings []BusBooking
+	if err := tx.Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+	var tickets []BusTicket
+	if err := tx.Where("revoked = ?", false).Find(&tickets).Error; err != nil {
+		return nil, err
+	}
+
+	active := make(map[uint]BusBooking, len(bookings))
+	for _, b := range bookings {
+		if b.Status != "cancelled" {
+			active[b.ID] = b
+		}
+	}
+	ticketsByBooking := make(map[uint][]BusTicket)
+	for _, t := range tickets {
+		ticketsByBooking[t.BusBookingID] = append(ticketsByBooking[t.BusBookingID], t)
+	}
+
+	var drifts []Drift
+
+	// Tickets that no longer belong to an active booking
+	for _, t := range tickets {
+		if _, ok := active[t.BusBookingID]; ok {
+			continue
+		}
+		d := Drift{
+			Kind:     "bus_ticket_orphaned",
+			EntityID: t.ID,
+			Expected: 0,
+			Actual:   1,
+			Detail:   "ticket of booking " + strconv.FormatUint(uint64(t.BusBookingID), 10) + " is still valid",
+		}
+		if repair {
+			if err := tx.Model(&t).Update("revoked", true).Error; err != nil {
+				return nil, err
+			}
+			d.Repaired = true
+		}
+		drifts = append(drifts, d)
+	}
+
+	// Active bookings without exactly one valid ticket
+	for _, b := range bookings {
+		if b.Status == "cancelled" {
+			continue
+		}
+		count := len(ticketsByBooking[b.ID])
+		if count == 1 {
+			continue
+		}
+		d := Drift{
+			Kind:     "bus_booking_tickets",
+			EntityID: strconv.FormatUint(uint64(b.ID), 10),
+			Expected: 1,
+			Actual:   count,
+		}
+		if count == 0 {
+			d.Detail = "booking has no valid ticket"
+			if repair {
+				ticket := BusTicket{
+					ID:           uuid.New().String(),
+					BusBookingID: b.ID,
+					TripID:       b.TripID,
+					SeatNumber:   b.SeatNumber,
+					FirstName:    b.FirstName,
+					LastName:     b.LastName,
+					Email:        b.Email,
+					CreatedAt:    time.Now(),
+				}
+				if err := tx.Create(&ticket).Error; err != nil {
+					return nil, err
+				}
+				d.Repaired = true
+			}
+		} else {
+			// Keep the oldest ticket so whatever the customer received first stays valid
+			d.Detail = "booking has more than one valid ticket"
+			if repair {
+				oldest := ticketsByBooking[b.ID][0]
+				for _, t := range ticketsByBooking[b.ID][1:] {
+					if t.CreatedAt.Before(oldest.CreatedAt) {
+						oldest = t
+					}
+				}
+				if err := tx.Model(&BusTicket{}).
+					Where("bus_booking_id = ? AND id <> ?", b.ID, oldest.ID).
+					Update("revoked", true).Error; err != nil {
+					return nil, err
+				}
+				d.Repaired = true
+			}
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, nil
+}
+
+// startReconcileJob checks the inventory on an interval set by RECONCILE_INTERVAL_MINUTES
+// (default 60) and logs any drift. Drift is repaired automatically when RECONCILE_REPAIR=true.
+func startReconcileJob(bookings BookingRepository) {
+	interval := time.Hour
+	if v, err := strconv.Atoi(os.Getenv("RECONCILE_INTERVAL_MINUTES")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Minute
+	}
+	repair := os.Getenv("RECONCILE_REPAIR") == "true"
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		drifts, err := bookings.ReconcileInventory(repair)
+		if err != nil {
+			log.Printf("Inventory reconciliation failed: %v", err)
+			continue
+		}
+		for _, d := range drifts {
+			log.Printf("Inventory drift: %s %s expected %d, found %d (%s, repaired: %t)",
+				d.Kind, d.EntityID, d.Expected, d.Actual, d.Detail, d.Repaired)
+		}
+	}
+}
+
+// Handler to report inventory drift; POST requests also repair it
+func reconcileHandler(bookings BookingRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		repair := c.Request.Method == http.MethodPost
+
+		drifts, err := bookings.ReconcileInventory(repair)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile inventory"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"drifts":   drifts,
+			"count":    len(drifts),
+			"repaired": repair,
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReconcileRepairsCounters(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	trip := createTestTrip(t, newRepositories(db), "Drift Coach", 5)
+	if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+		FirstName: "Dee", LastName: "Drift", Email: "dee@example.com", SelectedSeats: []int{1, 2},
+	}, nil, nil); status != http.StatusOK {
+		t.Fatalf("book: status %d", status)
+	}
+	conference := Conference{Title: "Drift Conf", Location: "Hall C", TimeZone: "UTC", TotalTickets: 10, RemainingTickets: 10,
+		StartsAt: time.Now().Add(24 * time.Hour), EndsAt: time.Now().Add(48 * time.Hour), Free: true}
+	if err := db.Create(&conference).Error; err != nil {
+		t.Fatalf("create conference: %v", err)
+	}
+	if status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/conference/%d/book", conference.ID), ConferenceBooking{
+		FirstName: "Dee", LastName: "Drift", Email: "dee@example.com", Tickets: 4,
+	}, nil, nil); status != http.StatusOK {
+		t.Fatalf("book conference: status %d", status)
+	}
+
+	db.Model(&Trip{}).Where("id = ?", trip.ID).Update("remaining_seats", 5)
+	db.Model(&Conference{}).Where("id = ?", conference.ID).Update("remaining_tickets", 1)
+
+	drifts, err := newRepositories(db).Bookings.ReconcileInventory(true)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if len(drifts) != 2 || !drifts[0].Repaired || !drifts[1].Repaired {
+		t.Fatalf("got drifts %+v, want two repaired", drifts)
+	}
+
+	var storedTrip Trip
+	db.First(&storedTrip, trip.ID)
+	if storedTrip.RemainingSeats != 3 {
+		t.Errorf("remaining seats = %d, want 3", storedTrip.RemainingSeats)
+	}
+	var storedConference Conference
+	db.First(&storedConference, conference.ID)
+	if storedConference.RemainingTickets != 6 {
+		t.Errorf("remaining tickets = %d, want 6", storedConference.RemainingTickets)
+	}
+
+	if drifts, err := newRepositories(db).Bookings.ReconcileInventory(false); err != nil || len(drifts) != 0 {
+		t.Fatalf("after repair: drifts %+v, error %v", drifts, err)
+	}
+}
@@ -0,0 +1,1170 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BusRepository stores routes, vehicles, and the trips they run with their seats
+type BusRepository interface {
+	ListRoutes() ([]Route, error)
+	GetRoute(id uint) (Route, error)
+	CreateRoute(route *Route) error
+	// FindOrCreateRoute loads the route with the same origin, destination and time zone, or
+	// creates it with its stops
+	FindOrCreateRoute(route *Route) error
+	ListVehicles() ([]Vehicle, error)
+	GetVehicle(id uint) (Vehicle, error)
+	CreateVehicle(vehicle *Vehicle) error
+	// FindOrCreateVehicle loads the vehicle with the same name, capacity and seat layout,
+	// or creates it
+	FindOrCreateVehicle(vehicle *Vehicle) error
+	ListSeatLayouts() ([]SeatLayout, error)
+	// GetSeatLayout returns the layout with its seats in seat number order
+	GetSeatLayout(id uint) (SeatLayout, error)
+	CreateSeatLayout(layout *SeatLayout) error
+	// TripSeatLayout returns the layout of the vehicle driving the trip, or the default
+	// layout for its number of seats when the vehicle has none
+	TripSeatLayout(trip Trip) (SeatLayout, error)
+	ListTrips() ([]Trip, error)
+	GetTrip(id uint) (Trip, error)
+	// CreateTrip stores the trip together with one available seat per TotalSeats
+	CreateTrip(trip *Trip) error
+	// SetTripPricing replaces the currency, tax rate, free flag and fares of a trip
+	SetTripPricing(tripID uint, currency string, taxBasisPoints int, free bool, fares []TripFare) error
+	// Seats returns the state of every seat over the segments from up to to
+	Seats(tripID uint, from, to int) ([]SeatAvailability, error)
+	// AvailableSeats returns the seats free on every segment from up to to
+	AvailableSeats(tripID uint, from, to int) ([]SeatAvailability, error)
+}
+
+// ScheduleRepository stores recurring schedules, the holiday calendar and the trips
+// generated from them
+type ScheduleRepository interface {
+	// ListSchedules returns the schedules with their route, vehicle and fares
+	ListSchedules() ([]Schedule, error)
+	GetSchedule(id uint) (Schedule, error)
+	// CreateSchedule stores the schedule together with its fares
+	CreateSchedule(schedule *Schedule) error
+	// SetSchedulePricing replaces the currency, tax rate, free flag and fares that
+	// trips generated from the schedule from now on get
+	SetSchedulePricing(scheduleID uint, currency string, taxBasisPoints int, free bool, fares []ScheduleFare) error
+	DeleteSchedule(id uint) error
+	ListHolidays() ([]Holiday, error)
+	CreateHoliday(holiday *Holiday) error
+	// CreateScheduledTrip stores the trip with its fares and seats unless its schedule
+	// already has a trip at that time, and reports whether it was created
+	CreateScheduledTrip(trip *Trip) (bool, error)
+}
+
+// ConferenceRepository stores conferences
+type ConferenceRepository interface {
+	ListConferences() ([]Conference, error)
+	GetConference(id uint) (Conference, error)
+	CreateConference(conference *Conference) error
+	// SetConferencePricing replaces the currency, tax rate, free flag and ticket tiers of
+	// a conference
+	SetConferencePricing(conferenceID uint, currency string, taxBasisPoints int, free bool, tiers []TicketTier) error
+}
+
+// BookingRepository stores bus and conference bookings and the seat holds before them.
+// Each write runs in one transaction that also queues the emails it sends.
+type BookingRepository interface {
+	BusBookings(tripID uint) ([]BusBooking, error)
+	AllBusBookings() ([]BusBooking, error)
+	GetBusBooking(id uint) (BusBooking, error)
+	GetConferenceBooking(id uint) (ConferenceBooking, error)
+	// ConferenceBookings leaves out fully cancelled bookings
+	ConferenceBookings(conferenceID uint) ([]ConferenceBooking, error)
+	AllConferenceBookings() ([]ConferenceBooking, error)
+	// UserBookings returns the bookings of a customer account, newest first, with their
+	// trips, conferences and tickets
+	UserBookings(userID uint) (CustomerBookings, error)
+	// EmailBookings is UserBookings for the bookings made with an email address
+	EmailBookings(email string) (CustomerBookings, error)
+	// BookSeats books the requested seats on a trip, one booking and ticket per seat
+	BookSeats(req BookingRequest, tripID uint, userID *uint) (SeatBooking, error)
+	// CancelBusBooking cancels a booking the caller may manage, frees its seat and
+	// revokes its ticket
+	CancelBusBooking(tripID, bookingID uint, allowed BookingAccess) (BusBooking, error)
+	// BookConference takes the booked tickets off the conference and issues them
+	BookConference(booking *ConferenceBooking) (TicketBooking, error)
+	// CancelConferenceBooking cancels some or, when tickets is 0, all tickets of a booking
+	// the caller may manage
+	CancelConferenceBooking(conferenceID, bookingID uint, tickets int, reason string, allowed BookingAccess) (TicketCancellation, error)
+	// HoldSeats holds the seats over the journey segments from up to to until expiresAt,
+	// unless another customer has any of them
+	HoldSeats(tripID uint, from, to int, seats []int, token string, expiresAt time.Time) error
+	// ReleaseSeats gives back the seats held by token, all of them when seats is empty
+	ReleaseSeats(tripID uint, token string, seats []int) (int64, error)
+	ReleaseExpiredHolds() (int64, error)
+	// ReconcileInventory compares the seat and ticket counters with the rows they count,
+	// fixing them when repair is set
+	ReconcileInventory(repair bool) ([]Drift, error)
+	Summary() (DashboardSummary, error)
+}
+
+// TicketRepository stores issued bus and conference tickets
+type TicketRepository interface {
+	GetBusTicket(id string) (BusTicket, error)
+	GetConferenceTicket(id string) (ConferenceTicket, error)
+	ConferenceTickets(bookingID uint) ([]ConferenceTicket, error)
+	// CheckInBusTicket marks a valid ticket for the trip used and reports whether this
+	// scan did so; the ticket is returned either way
+	CheckInBusTicket(id string, tripID uint, deviceID string) (BusTicket, bool, error)
+	// AssignAttendee names the attendee of a conference ticket the caller may manage and
+	// queues their ticket email when an address is given
+	AssignAttendee(conferenceID uint, ticketID, name, email string, allowed BookingAccess) (ConferenceTicket, error)
+}
+
+// UserRepository stores customer and staff accounts
+type UserRepository interface {
+	GetUser(id uint) (User, error)
+	// FindUserByEmail looks the account up by its lower-case email
+	FindUserByEmail(email string) (User, error)
+	// CreateUser stores a new account; a taken email gives gorm.ErrDuplicatedKey
+	CreateUser(user *User) error
+	ListUsers() ([]User, error)
+	SetUserRole(id uint, role string) (User, error)
+}
+
+// BookingAccess reports whether the caller may manage a booking with the given owner and email
+type BookingAccess func(ownerID *uint, email string) bool
+
+// RuleError is a request the booking rules refuse. Its message and details are shown
+// to the customer; Conflict marks refusals caused by another customer.
+type RuleError struct {
+	Message  string
+	Conflict bool
+	Details  map[string]interface{}
+}
+
+func (e *RuleError) Error() string {
+	return e.Message
+}
+
+// errNotAllowed is returned when the caller may not manage the booking or ticket
+var errNotAllowed = errors.New("not allowed")
+
+// SeatBooking is the outcome of booking seats on a trip
+type SeatBooking struct {
+	SeatNumbers  []int
+	TicketIDs    []string
+	TicketTokens []string
+	Remaining    int
+	Quote        Quote
+}
+
+// TicketBooking is the outcome of booking conference tickets
+type TicketBooking struct {
+	TicketIDs []string
+	Remaining int
+}
+
+// CustomerBookings are the bookings of one customer with what they were booked for
+type CustomerBookings struct {
+	Bus         []CustomerBusBooking
+	Conferences []CustomerConferenceBooking
+}
+
+type CustomerBusBooking struct {
+	Booking BusBooking
+	Trip    Trip
+	Tickets []BusTicket
+}
+
+type CustomerConferenceBooking struct {
+	Booking    ConferenceBooking
+	Conference Conference
+	Tickets    []ConferenceTicket
+}
+
+// DashboardSummary counts the trips, conferences and bookings shown on the dashboard
+type DashboardSummary struct {
+	BusCount                        int64         `json:"busCount"`
+	ConferenceCount                 int64         `json:"conferenceCount"`
+	BusBookingCount                 int64         `json:"busBookingCount"`
+	ConferenceBookingCount          int64         `json:"conferenceBookingCount"`
+	TotalBusSeats                   sql.NullInt64 `json:"totalBusSeats"`
+	TotalConferenceTickets          sql.NullInt64 `json:"totalConferenceTickets"`
+	TotalBusSeatsBooked             int64         `json:"totalBusSeatsBooked"`
+	TotalConferenceTicketsBooked    int64         `json:"totalConferenceTicketsBooked"`
+	TotalConferenceTicketsCancelled int64         `json:"totalConferenceTicketsCancelled"`
+}
+
+// TicketCancellation is the outcome of cancelling conference tickets
+type TicketCancellation struct {
+	Status    string
+	Cancelled int
+	Remaining int
+}
+
+// Repositories bundles the stores the handlers are given. The outbox and idempotency
+// keys are not part of them: outbox messages are written in the transaction of the
+// change they announce, and both are given the connection itself.
+type Repositories struct {
+	Buses       BusRepository
+	Schedules   ScheduleRepository
+	Conferences ConferenceRepository
+	Bookings    BookingRepository
+	Tickets     TicketRepository
+	Users       UserRepository
+}
+
+// gormRepository implements every repository on top of a GORM connection, so the
+// same code serves MySQL, Postgres, SQLite files and in-memory SQLite (DB_DRIVER=memory)
+type gormRepository struct {
+	db *gorm.DB
+}
+
+// newRepositories returns repositories backed by the given connection
+func newRepositories(conn *gorm.DB) Repositories {
+	repo := &gormRepository{db: conn}
+	return Repositories{
+		Buses:       repo,
+		Schedules:   repo,
+		Conferences: repo,
+		Bookings:    repo,
+		Tickets:     repo,
+		Users:       repo,
+	}
+}
+
+func (r *gormRepository) ListRoutes() ([]Route, error) {
+	var routes []Route
+	err := r.db.Preload("Stops", orderedStops).Find(&routes).Error
+	return routes, err
+}
+
+func (r *gormRepository) GetRoute(id uint) (Route, error) {
+	var route Route
+	err := r.db.Preload("Stops", orderedStops).First(&route, id).Error
+	return route, err
+}
+
+func (r *gormRepository) CreateRoute(route *Route) error {
+	return r.db.Create(route).Error
+}
+
+func (r *gormRepository) FindOrCreateRoute(route *Route) error {
+	var existing Route
+	err := r.db.Preload("Stops", orderedStops).
+		Where("origin = ? AND destination = ? AND time_zone = ?", route.Origin, route.Destination, route.TimeZone).
+		First(&existing).Error
+	if err == nil {
+		*route = existing
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return r.db.Create(route).Error
+}
+
+func (r *gormRepository) ListVehicles() ([]Vehicle, error) {
+	var vehicles []Vehicle
+	err := r.db.Find(&vehicles).Error
+	return vehicles, err
+}
+
+func (r *gormRepository) GetVehicle(id uint) (Vehicle, error) {
+	var vehicle Vehicle
+	err := r.db.First(&vehicle, id).Error
+	return vehicle, err
+}
+
+func (r *gormRepository) CreateVehicle(vehicle *Vehicle) error {
+	return r.db.Create(vehicle).Error
+}
+
+func (r *gormRepository) FindOrCreateVehicle(vehicle *Vehicle) error {
+	return r.db.Where(map[string]interface{}{
+		"name":           vehicle.Name,
+		"capacity":       vehicle.Capacity,
+		"seat_layout_id": vehicle.SeatLayoutID,
+	}).FirstOrCreate(vehicle).Error
+}
+
+// orderedLayoutSeats sorts preloaded layout seats by seat number
+func orderedLayoutSeats(conn *gorm.DB) *gorm.DB {
+	return conn.Order("seat_number")
+}
+
+func (r *gormRepository) ListSeatLayouts() ([]SeatLayout, error) {
+	var layouts []SeatLayout
+	err := r.db.Preload("Seats", orderedLayoutSeats).Find(&layouts).Error
+	return layouts, err
+}
+
+func (r *gormRepository) GetSeatLayout(id uint) (SeatLayout, error) {
+	return loadSeatLayout(r.db, id)
+}
+
+// loadSeatLayout loads a layout with its seats in seat number order
+func loadSeatLayout(conn *gorm.DB, id uint) (SeatLayout, error) {
+	var layout SeatLayout
+	err := conn.Preload("Seats", orderedLayoutSeats).First(&layout, id).Error
+	return layout, err
+}
+
+func (r *gormRepository) CreateSeatLayout(layout *SeatLayout) error {
+	return r.db.Create(layout).Error
+}
+
+func (r *gormRepository) TripSeatLayout(trip Trip) (SeatLayout, error) {
+	return tripSeatLayout(r.db, trip)
+}
+
+// tripSeatLayout loads the layout of a trip whose vehicle is loaded
+func tripSeatLayout(conn *gorm.DB, trip Trip) (SeatLayout, error) {
+	if trip.Vehicle.SeatLayoutID == nil {
+		return defaultSeatLayout(trip.TotalSeats), nil
+	}
+	return loadSeatLayout(conn, *trip.Vehicle.SeatLayoutID)
+}
+
+func (r *gormRepository) ListTrips() ([]Trip, error) {
+	var trips []Trip
+	err := withTripDetails(r.db).Order("departs_at").Find(&trips).Error
+	return trips, err
+}
+
+func (r *gormRepository) GetTrip(id uint) (Trip, error) {
+	var trip Trip
+	err := withTripDetails(r.db).First(&trip, id).Error
+	return trip, err
+}
+
+func (r *gormRepository) CreateTrip(trip *Trip) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(trip).Error; err != nil {
+			return err
+		}
+		if err := createTripFares(tx, trip); err != nil {
+			return err
+		}
+		return createTripSeats(tx, *trip)
+	})
+}
+
+// createTripFares stores the fares of a new trip
+func createTripFares(tx *gorm.DB, trip *Trip) error {
+	if len(trip.Fares) == 0 {
+		return nil
+	}
+	for i := range trip.Fares {
+		trip.Fares[i].TripID = trip.ID
+	}
+	return tx.Create(&trip.Fares).Error
+}
+
+func (r *gormRepository) SetTripPricing(tripID uint, currency string, taxBasisPoints int, free bool, fares []TripFare) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Trip{}).Where("id = ?", tripID).Updates(map[string]interface{}{
+			"currency":         currency,
+			"tax_basis_points": taxBasisPoints,
+			"free":             free,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("trip_id = ?", tripID).Delete(&TripFare{}).Error; err != nil {
+			return err
+		}
+		if len(fares) == 0 {
+			return nil
+		}
+		for i := range fares {
+			fares[i].TripID = tripID
+		}
+		return tx.Create(&fares).Error
+	})
+}
+
+// createTripSeats stores the seat rows of a new trip, one per seat and route segment
+func createTripSeats(tx *gorm.DB, trip Trip) error {
+	if trip.TotalSeats <= 0 {
+		return nil
+	}
+	var stops []RouteStop
+	if err := tx.Where("route_id = ?", trip.RouteID).Find(&stops).Error; err != nil {
+		return err
+	}
+	seats := tripSeats(trip, segmentCount(stops))
+	return tx.CreateInBatches(&seats, 500).Error
+}
+
+func (r *gormRepository) Seats(tripID uint, from, to int) ([]SeatAvailability, error) {
+	var rows []BusSeat
+	err := r.db.Where("trip_id = ? AND segment >= ? AND segment < ?", tripID, from, to).
+		Order("seat_number").Order("segment").Find(&rows).Error
+	return seatAvailability(rows), err
+}
+
+func (r *gormRepository) AvailableSeats(tripID uint, from, to int) ([]SeatAvailability, error) {
+	seats, err := r.Seats(tripID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	available := []SeatAvailability{}
+	for _, seat := range seats {
+		if seat.Status == "available" {
+			available = append(available, seat)
+		}
+	}
+	return available, nil
+}
+
+func (r *gormRepository) ListSchedules() ([]Schedule, error) {
+	var schedules []Schedule
+	err := r.db.Preload("Route").Preload("Vehicle").Preload("Fares").Find(&schedules).Error
+	return schedules, err
+}
+
+func (r *gormRepository) GetSchedule(id uint) (Schedule, error) {
+	var schedule Schedule
+	err := r.db.Preload("Route").Preload("Vehicle").Preload("Fares").First(&schedule, id).Error
+	return schedule, err
+}
+
+func (r *gormRepository) CreateSchedule(schedule *Schedule) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(schedule).Error; err != nil {
+			return err
+		}
+		if len(schedule.Fares) == 0 {
+			return nil
+		}
+		for i := range schedule.Fares {
+			schedule.Fares[i].ScheduleID = schedule.ID
+		}
+		return tx.Create(&schedule.Fares).Error
+	})
+}
+
+func (r *gormRepository) SetSchedulePricing(scheduleID uint, currency string, taxBasisPoints int, free bool, fares []ScheduleFare) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Schedule{}).Where("id = ?", scheduleID).Updates(map[string]interface{}{
+			"currency":         currency,
+			"tax_basis_points": taxBasisPoints,
+			"free":             free,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("schedule_id = ?", scheduleID).Delete(&ScheduleFare{}).Error; err != nil {
+			return err
+		}
+		if len(fares) == 0 {
+			return nil
+		}
+		for i := range fares {
+			fares[i].ScheduleID = scheduleID
+		}
+		return tx.Create(&fares).Error
+	})
+}
+
+func (r *gormRepository) DeleteSchedule(id uint) error {
+	return r.db.Delete(&Schedule{}, id).Error
+}
+
+func (r *gormRepository) ListHolidays() ([]Holiday, error) {
+	var holidays []Holiday
+	err := r.db.Order("date").Find(&holidays).Error
+	return holidays, err
+}
+
+func (r *gormRepository) CreateHoliday(holiday *Holiday) error {
+	return r.db.Create(holiday).Error
+}
+
+func (r *gormRepository) CreateScheduledTrip(trip *Trip) (bool, error) {
+	inserted := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&Trip{}).Where("schedule_id = ? AND departs_at = ?", trip.ScheduleID, trip.DepartsAt).
+			Count(&existing).Error; err != nil || existing > 0 {
+			return err
+		}
+		// The unique index still settles two generators racing for the same departure
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(trip)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		inserted = true
+		if err := createTripFares(tx, trip); err != nil {
+			return err
+		}
+		return createTripSeats(tx, *trip)
+	})
+	return inserted, err
+}
+
+func (r *gormRepository) ListConferences() ([]Conference, error) {
+	var conferences []Conference
+	err := r.db.Preload("Tiers").Find(&conferences).Error
+	return conferences, err
+}
+
+func (r *gormRepository) GetConference(id uint) (Conference, error) {
+	var conference Conference
+	err := r.db.Preload("Tiers").First(&conference, id).Error
+	return conference, err
+}
+
+func (r *gormRepository) CreateConference(conference *Conference) error {
+	return r.db.Create(conference).Error
+}
+
+func (r *gormRepository) SetConferencePricing(conferenceID uint, currency string, taxBasisPoints int, free bool, tiers []TicketTier) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Conference{}).Where("id = ?", conferenceID).Updates(map[string]interface{}{
+			"currency":         currency,
+			"tax_basis_points": taxBasisPoints,
+			"free":             free,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conference_id = ?", conferenceID).Delete(&TicketTier{}).Error; err != nil {
+			return err
+		}
+		if len(tiers) == 0 {
+			return nil
+		}
+		for i := range tiers {
+			tiers[i].ConferenceID = conferenceID
+		}
+		return tx.Create(&tiers).Error
+	})
+}
+
+func (r *gormRepository) BusBookings(tripID uint) ([]BusBooking, error) {
+	var bookings []BusBooking
+	err := r.db.Where("trip_id = ?", tripID).Find(&bookings).Error
+	return bookings, err
+}
+
+func (r *gormRepository) AllBusBookings() ([]BusBooking, error) {
+	var bookings []BusBooking
+	err := r.db.Find(&bookings).Error
+	return bookings, err
+}
+
+func (r *gormRepository) GetBusBooking(id uint) (BusBooking, error) {
+	var booking BusBooking
+	err := r.db.First(&booking, id).Error
+	return booking, err
+}
+
+func (r *gormRepository) GetConferenceBooking(id uint) (ConferenceBooking, error) {
+	var booking ConferenceBooking
+	err := r.db.First(&booking, id).Error
+	return booking, err
+}
+
+func (r *gormRepository) ConferenceBookings(conferenceID uint) ([]ConferenceBooking, error) {
+	var bookings []ConferenceBooking
+	err := r.db.Where("conference_id = ? AND status <> ?", conferenceID, "cancelled").Find(&bookings).Error
+	return bookings, err
+}
+
+func (r *gormRepository) AllConferenceBookings() ([]ConferenceBooking, error) {
+	var bookings []ConferenceBooking
+	err := r.db.Find(&bookings).Error
+	return bookings, err
+}
+
+func (r *gormRepository) UserBookings(userID uint) (CustomerBookings, error) {
+	return r.customerBookings(r.db.Where("user_id = ?", userID), r.db.Where("user_id = ?", userID))
+}
+
+func (r *gormRepository) EmailBookings(email string) (CustomerBookings, error) {
+	email = strings.ToLower(email)
+	return r.customerBookings(r.db.Where("LOWER(email) = ?", email), r.db.Where("LOWER(email) = ?", email))
+}
+
+// customerBookings loads the bookings matched by the given queries with their trips,
+// conferences and tickets
+func (r *gormRepository) customerBookings(busQuery, conferenceQuery *gorm.DB) (CustomerBookings, error) {
+	result := CustomerBookings{Bus: []CustomerBusBooking{}, Conferences: []CustomerConferenceBooking{}}
+
+	var busBookings []BusBooking
+	if err := busQuery.Order("created_at desc").Find(&busBookings).Error; err != nil {
+		return result, err
+	}
+	// Bookings show their trip and conference even when those were deleted since
+	trips := map[uint]Trip{}
+	for _, booking := range busBookings {
+		trip, ok := trips[booking.TripID]
+		if !ok {
+			if err := withTripDetails(r.db.Unscoped()).First(&trip, booking.TripID).Error; err != nil {
+				return result, err
+			}
+			trips[booking.TripID] = trip
+		}
+		var tickets []BusTicket
+		if err := r.db.Where("bus_booking_id = ?", booking.ID).Find(&tickets).Error; err != nil {
+			return result, err
+		}
+		result.Bus = append(result.Bus, CustomerBusBooking{Booking: booking, Trip: trip, Tickets: tickets})
+	}
+
+	var conferenceBookings []ConferenceBooking
+	if err := conferenceQuery.Order("created_at desc").Find(&conferenceBookings).Error; err != nil {
+		return result, err
+	}
+	conferences := map[uint]Conference{}
+	for _, booking := range conferenceBookings {
+		conference, ok := conferences[booking.ConferenceID]
+		if !ok {
+			if err := r.db.Unscoped().First(&conference, booking.ConferenceID).Error; err != nil {
+				return result, err
+			}
+			conferences[booking.ConferenceID] = conference
+		}
+		var tickets []ConferenceTicket
+		if err := r.db.Where("conference_booking_id = ?", booking.ID).Find(&tickets).Error; err != nil {
+			return result, err
+		}
+		result.Conferences = append(result.Conferences, CustomerConferenceBooking{Booking: booking, Conference: conference, Tickets: tickets})
+	}
+	return result, nil
+}
+
+func (r *gormRepository) GetBusTicket(id string) (BusTicket, error) {
+	var ticket BusTicket
+	err := r.db.First(&ticket, "id = ?", id).Error
+	return ticket, err
+}
+
+func (r *gormRepository) GetConferenceTicket(id string) (ConferenceTicket, error) {
+	var ticket ConferenceTicket
+	err := r.db.First(&ticket, "id = ?", id).Error
+	return ticket, err
+}
+
+func (r *gormRepository) ConferenceTickets(bookingID uint) ([]ConferenceTicket, error) {
+	var tickets []ConferenceTicket
+	err := r.db.Where("conference_booking_id = ?", bookingID).Order("created_at").Find(&tickets).Error
+	return tickets, err
+}
+
+func (r *gormRepository) BookSeats(req BookingRequest, tripID uint, userID *uint) (SeatBooking, error) {
+	var booked SeatBooking
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var trip Trip
+		if err := withTripDetails(tx).First(&trip, tripID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &RuleError{Message: "Bus not found"}
+			}
+			return err
+		}
+
+		journey, err := tripJourney(trip, req.BoardingStopID, req.AlightingStopID)
+		if err != nil {
+			return &RuleError{Message: err.Error()}
+		}
+
+		seatNumbers := uniqueSeats(req.SelectedSeats)
+		sort.Ints(seatNumbers)
+
+		// Lock every segment of the selected seats in one query, in seat order so concurrent
+		// bookings acquire the locks in the same order. Segments outside the journey are
+		// locked as well to tell whether a seat was still free for the whole trip.
+		var rows []BusSeat
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("trip_id = ? AND seat_number IN ?", tripID, seatNumbers).
+			Order("seat_number").Order("segment").
+			Find(&rows).Error; err != nil {
+			return fmt.Errorf("lock seats: %w", err)
+		}
+
+		journeySegments := make(map[int]int, len(seatNumbers))
+		wasFree := make(map[int]bool, len(seatNumbers))
+		for _, seatNum := range seatNumbers {
+			wasFree[seatNum] = true
+		}
+		for _, row := range rows {
+			if row.SeatStatus == "booked" {
+				wasFree[row.SeatNumber] = false
+			}
+			if row.Segment < journey.From || row.Segment >= journey.To {
+				continue
+			}
+			journeySegments[row.SeatNumber]++
+			if err := seatRefusal(row, req.HoldToken); err != nil {
+				return err
+			}
+		}
+		for _, seatNum := range seatNumbers {
+			if journeySegments[seatNum] != journey.To-journey.From {
+				return &RuleError{Message: fmt.Sprintf("Seat %d not found", seatNum)}
+			}
+		}
+
+		layout, err := tripSeatLayout(tx, trip)
+		if err != nil {
+			return fmt.Errorf("fetch seat layout: %w", err)
+		}
+		quote, err := busQuote(trip, journey, seatNumbers, layout)
+		if err != nil {
+			return &RuleError{Message: err.Error()}
+		}
+
+		var tickets []BusTicket
+		var ticketIDs, ticketTokens []string
+		newlyTaken := 0
+		for i, seatNum := range seatNumbers {
+			line := quote.Lines[i]
+			booking := BusBooking{
+				FirstName:       req.FirstName,
+				LastName:        req.LastName,
+				Email:           req.Email,
+				SeatNumber:      seatNum,
+				TripID:          tripID,
+				BusName:         trip.Vehicle.Name,
+				BookedAt:        time.Now(),
+				UserID:          userID,
+				BoardingStopID:  journey.Boarding.ID,
+				BoardingStop:    journey.Boarding.Name,
+				AlightingStopID: journey.Alighting.ID,
+				AlightingStop:   journey.Alighting.Name,
+				SeatClass:       line.SeatClass,
+				Currency:        quote.Currency,
+				Price:           line.Subtotal,
+				Tax:             line.Tax,
+				Total:           line.Total,
+			}
+			if err := tx.Create(&booking).Error; err != nil {
+				return fmt.Errorf("create booking: %w", err)
+			}
+
+			if err := tx.Model(&BusSeat{}).
+				Where("trip_id = ? AND seat_number = ? AND segment >= ? AND segment < ?", tripID, seatNum, journey.From, journey.To).
+				Updates(map[string]interface{}{
+					"seat_status":     "booked",
+					"booking_id":      booking.ID,
+					"hold_token":      "",
+					"hold_expires_at": nil,
+				}).Error; err != nil {
+				return fmt.Errorf("update seat status: %w", err)
+			}
+			if wasFree[seatNum] {
+				newlyTaken++
+			}
+
+			ticket := BusTicket{
+				ID:           uuid.New().String(),
+				BusBookingID: booking.ID,
+				TripID:       tripID,
+				SeatNumber:   seatNum,
+				FirstName:    req.FirstName,
+				LastName:     req.LastName,
+				Email:        req.Email,
+				Used:         false,
+				CreatedAt:    time.Now(),
+			}
+			if err := tx.Create(&ticket).Error; err != nil {
+				return fmt.Errorf("create ticket: %w", err)
+			}
+			token, err := busTicketToken(ticket)
+			if err != nil {
+				return fmt.Errorf("sign ticket: %w", err)
+			}
+			tickets = append(tickets, ticket)
+			ticketIDs = append(ticketIDs, ticket.ID)
+			ticketTokens = append(ticketTokens, token)
+		}
+
+		// The rest of a hold that covered more of the trip than was booked is given back
+		if req.HoldToken != "" {
+			if err := tx.Model(&BusSeat{}).
+				Where("trip_id = ? AND seat_number IN ? AND seat_status = ? AND hold_token = ?",
+					tripID, seatNumbers, "held", req.HoldToken).
+				Updates(map[string]interface{}{
+					"seat_status":     "available",
+					"hold_token":      "",
+					"hold_expires_at": nil,
+				}).Error; err != nil {
+				return fmt.Errorf("release held seats: %w", err)
+			}
+		}
+
+		// RemainingSeats counts the seats free for the whole trip, so only seats that had
+		// no booked segment before are taken off it
+		if newlyTaken > 0 {
+			if err := tx.Model(&Trip{}).Where("id = ?", trip.ID).
+				Update("remaining_seats", gorm.Expr("remaining_seats - ?", newlyTaken)).Error; err != nil {
+				return fmt.Errorf("update remaining seats: %w", err)
+			}
+		}
+
+		if err := enqueueMessage(tx, "bus_tickets", strings.Join(ticketIDs, ","),
+			busTicketMessage(trip, journey, tickets, quote)); err != nil {
+			return fmt.Errorf("queue ticket email: %w", err)
+		}
+
+		booked = SeatBooking{
+			SeatNumbers:  seatNumbers,
+			TicketIDs:    ticketIDs,
+			TicketTokens: ticketTokens,
+			Remaining:    trip.RemainingSeats - newlyTaken,
+			Quote:        quote,
+		}
+		return nil
+	})
+	return booked, err
+}
+
+func (r *gormRepository) CancelBusBooking(tripID, bookingID uint, allowed BookingAccess) (BusBooking, error) {
+	var booking BusBooking
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND trip_id = ?", bookingID, tripID).
+			First(&booking).Error; err != nil {
+			return err
+		}
+		if !allowed(booking.UserID, booking.Email) {
+			return errNotAllowed
+		}
+		if booking.Status == "cancelled" {
+			return &RuleError{Message: "Booking already cancelled"}
+		}
+
+		if err := tx.Model(&booking).Update("status", "cancelled").Error; err != nil {
+			return err
+		}
+
+		// Lock every segment of the seat, then release the ones held by this booking
+		var rows []BusSeat
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("trip_id = ? AND seat_number = ?", booking.TripID, booking.SeatNumber).
+			Find(&rows).Error; err != nil {
+			return fmt.Errorf("lock seat: %w", err)
+		}
+		if err := tx.Model(&BusSeat{}).
+			Where("trip_id = ? AND booking_id = ?", booking.TripID, booking.ID).
+			Updates(map[string]interface{}{
+				"seat_status": "available",
+				"booking_id":  0,
+			}).Error; err != nil {
+			return fmt.Errorf("release seat: %w", err)
+		}
+
+		// The seat is free for the whole trip again unless another journey still has it
+		freed := true
+		for _, row := range rows {
+			if row.SeatStatus == "booked" && row.BookingID != booking.ID {
+				freed = false
+			}
+		}
+		if freed {
+			if err := tx.Model(&Trip{}).Where("id = ?", booking.TripID).
+				Update("remaining_seats", gorm.Expr("remaining_seats + ?", 1)).Error; err != nil {
+				return fmt.Errorf("update remaining seats: %w", err)
+			}
+		}
+
+		return tx.Model(&BusTicket{}).Where("bus_booking_id = ?", booking.ID).Update("revoked", true).Error
+	})
+	return booking, err
+}
+
+func (r *gormRepository) BookConference(booking *ConferenceBooking) (TicketBooking, error) {
+	var booked TicketBooking
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Take the tickets only if enough are left; the check and the decrement are one
+		// statement, so concurrent bookings cannot drive remaining_tickets below zero
+		result := tx.Model(&Conference{}).
+			Where("id = ? AND remaining_tickets >= ?", booking.ConferenceID, booking.Tickets).
+			Update("remaining_tickets", gorm.Expr("remaining_tickets - ?", booking.Tickets))
+		if result.Error != nil {
+			return fmt.Errorf("take tickets: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			var conference Conference
+			tx.Select("remaining_tickets").First(&conference, booking.ConferenceID)
+			return &RuleError{Message: "Not enough tickets left", Details: map[string]interface{}{"remaining": conference.RemainingTickets}}
+		}
+		var conference Conference
+		if err := tx.First(&conference, booking.ConferenceID).Error; err != nil {
+			return fmt.Errorf("load conference: %w", err)
+		}
+
+		if err := tx.Create(booking).Error; err != nil {
+			return fmt.Errorf("create booking: %w", err)
+		}
+
+		// Issue one ticket per seat so attendees can be assigned individually
+		var ticketIDs []string
+		for i := 0; i < booking.Tickets; i++ {
+			ticket := ConferenceTicket{
+				ID:                  uuid.New().String(),
+				ConferenceBookingID: booking.ID,
+				ConferenceID:        conference.ID,
+			}
+			if err := tx.Create(&ticket).Error; err != nil {
+				return fmt.Errorf("create ticket: %w", err)
+			}
+			ticketIDs = append(ticketIDs, ticket.ID)
+		}
+
+		// Queue the ticket email with the booking so it survives a crash after commit
+		if err := enqueueMessage(tx, "conference_tickets", strings.Join(ticketIDs, ","),
+			conferenceTicketMessage(conference, *booking)); err != nil {
+			return fmt.Errorf("queue ticket email: %w", err)
+		}
+
+		booked = TicketBooking{TicketIDs: ticketIDs, Remaining: conference.RemainingTickets}
+		return nil
+	})
+	return booked, err
+}
+
+func (r *gormRepository) CancelConferenceBooking(conferenceID, bookingID uint, tickets int, reason string, allowed BookingAccess) (TicketCancellation, error) {
+	var cancelled TicketCancellation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var booking ConferenceBooking
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND conference_id = ?", bookingID, conferenceID).
+			First(&booking).Error; err != nil {
+			return err
+		}
+		if !allowed(booking.UserID, booking.Email) {
+			return errNotAllowed
+		}
+		if booking.Status == "cancelled" || booking.Tickets == 0 {
+			return &RuleError{Message: "Booking already cancelled"}
+		}
+
+		if tickets == 0 {
+			tickets = booking.Tickets
+		}
+		if tickets > booking.Tickets {
+			return &RuleError{Message: "Cannot cancel more tickets than booked", Details: map[string]interface{}{"booked": booking.Tickets}}
+		}
+
+		status := "partially_cancelled"
+		if tickets == booking.Tickets {
+			status = "cancelled"
+		}
+
+		// Booking keeps the net ticket count, the cancelled ones are tracked separately
+		if err := tx.Model(&booking).Updates(map[string]interface{}{
+			"tickets":           gorm.Expr("tickets - ?", tickets),
+			"cancelled_tickets": gorm.Expr("cancelled_tickets + ?", tickets),
+			"status":            status,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Conference{}).Where("id = ?", booking.ConferenceID).
+			Update("remaining_tickets", gorm.Expr("remaining_tickets + ?", tickets)).Error; err != nil {
+			return fmt.Errorf("return tickets: %w", err)
+		}
+
+		// Revoke unassigned tickets first, then the most recently issued ones
+		var revoke []string
+		if err := tx.Model(&ConferenceTicket{}).
+			Where("conference_booking_id = ? AND revoked = ?", booking.ID, false).
+			Order("attendee_email <> ''").Order("created_at desc").
+			Limit(tickets).Pluck("id", &revoke).Error; err != nil {
+			return fmt.Errorf("load tickets: %w", err)
+		}
+		if len(revoke) > 0 {
+			if err := tx.Model(&ConferenceTicket{}).Where("id IN ?", revoke).
+				Update("revoked", true).Error; err != nil {
+				return fmt.Errorf("revoke tickets: %w", err)
+			}
+		}
+
+		if err := tx.Create(&ConferenceCancellation{
+			ConferenceBookingID: booking.ID,
+			ConferenceID:        booking.ConferenceID,
+			Tickets:             tickets,
+			Reason:              reason,
+		}).Error; err != nil {
+			return fmt.Errorf("record cancellation: %w", err)
+		}
+
+		cancelled = TicketCancellation{Status: status, Cancelled: tickets, Remaining: booking.Tickets - tickets}
+		return nil
+	})
+	return cancelled, err
+}
+
+func (r *gormRepository) HoldSeats(tripID uint, from, to int, seats []int, token string, expiresAt time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Only take seats that are free, already ours, or whose hold has lapsed, on every
+		// segment of the journey
+		result := tx.Model(&BusSeat{}).
+			Where("trip_id = ? AND seat_number IN ? AND segment >= ? AND segment < ?", tripID, seats, from, to).
+			Where("seat_status = ? OR (seat_status = ? AND (hold_token = ? OR hold_expires_at < ?))",
+				"available", "held", token, time.Now()).
+			Updates(map[string]interface{}{
+				"seat_status":     "held",
+				"hold_token":      token,
+				"hold_expires_at": expiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(seats)*(to-from)) {
+			return &RuleError{Message: "One or more seats are no longer available", Conflict: true}
+		}
+		return nil
+	})
+}
+
+func (r *gormRepository) ReleaseSeats(tripID uint, token string, seats []int) (int64, error) {
+	query := r.db.Model(&BusSeat{}).
+		Where("trip_id = ? AND seat_status = ? AND hold_token = ?", tripID, "held", token)
+	if len(seats) > 0 {
+		query = query.Where("seat_number IN ?", seats)
+	}
+	result := query.Updates(map[string]interface{}{
+		"seat_status":     "available",
+		"hold_token":      "",
+		"hold_expires_at": nil,
+	})
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormRepository) ReleaseExpiredHolds() (int64, error) {
+	result := r.db.Model(&BusSeat{}).
+		Where("seat_status = ? AND hold_expires_at < ?", "held", time.Now()).
+		Updates(map[string]interface{}{
+			"seat_status":     "available",
+			"hold_token":      "",
+			"hold_expires_at": nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormRepository) ReconcileInventory(repair bool) ([]Drift, error) {
+	return reconcileInventory(r.db, repair)
+}
+
+func (r *gormRepository) Summary() (DashboardSummary, error) {
+	var s DashboardSummary
+	for _, q := range []*gorm.DB{
+		r.db.Model(&Trip{}).Count(&s.BusCount),
+		r.db.Model(&Conference{}).Count(&s.ConferenceCount),
+		r.db.Model(&BusBooking{}).Count(&s.BusBookingCount),
+		r.db.Model(&ConferenceBooking{}).Where("status <> ?", "cancelled").Count(&s.ConferenceBookingCount),
+		r.db.Model(&Trip{}).Select("SUM(total_seats)").Scan(&s.TotalBusSeats),
+		r.db.Model(&Conference{}).Select("SUM(total_tickets)").Scan(&s.TotalConferenceTickets),
+		// Each bus booking holds a single seat
+		r.db.Model(&BusBooking{}).Where("status <> ?", "cancelled").Count(&s.TotalBusSeatsBooked),
+		r.db.Model(&ConferenceBooking{}).Select("COALESCE(SUM(tickets), 0)").Scan(&s.TotalConferenceTicketsBooked),
+		r.db.Model(&ConferenceCancellation{}).Select("COALESCE(SUM(tickets), 0)").Scan(&s.TotalConferenceTicketsCancelled),
+	} {
+		if q.Error != nil {
+			return s, q.Error
+		}
+	}
+	return s, nil
+}
+
+func (r *gormRepository) CheckInBusTicket(id string, tripID uint, deviceID string) (BusTicket, bool, error) {
+	// Mark the ticket used only if it is still valid for this bus, so two scans cannot both succeed
+	result := r.db.Model(&BusTicket{}).
+		Where("id = ? AND trip_id = ? AND used = ? AND revoked = ?", id, tripID, false, false).
+		Updates(map[string]interface{}{
+			"used":          true,
+			"used_at":       time.Now(),
+			"checked_in_by": deviceID,
+		})
+	if result.Error != nil {
+		return BusTicket{}, false, result.Error
+	}
+	ticket, err := r.GetBusTicket(id)
+	return ticket, result.RowsAffected > 0, err
+}
+
+func (r *gormRepository) AssignAttendee(conferenceID uint, ticketID, name, email string, allowed BookingAccess) (ConferenceTicket, error) {
+	var ticket ConferenceTicket
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND conference_id = ?", ticketID, conferenceID).First(&ticket).Error; err != nil {
+			return err
+		}
+		var booking ConferenceBooking
+		if err := tx.First(&booking, ticket.ConferenceBookingID).Error; err != nil {
+			return err
+		}
+		if !allowed(booking.UserID, booking.Email) {
+			return errNotAllowed
+		}
+		if ticket.Revoked {
+			return &RuleError{Message: "Ticket has been cancelled"}
+		}
+
+		// A new version retires the token of the previous attendee
+		if err := tx.Model(&ticket).Updates(map[string]interface{}{
+			"attendee_name":  name,
+			"attendee_email": email,
+			"version":        gorm.Expr("version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&ticket, "id = ?", ticket.ID).Error; err != nil {
+			return err
+		}
+
+		// Send the badge straight to the attendee when we know their address
+		if email == "" {
+			return nil
+		}
+		var conference Conference
+		if err := tx.First(&conference, ticket.ConferenceID).Error; err != nil {
+			return fmt.Errorf("load conference: %w", err)
+		}
+		return enqueueMessage(tx, "conference_tickets", ticket.ID, Message{
+			To:      email,
+			Subject: fmt.Sprintf("Your ticket for %s", conference.Title),
+			Body: fmt.Sprintf("Hi %s,\n\nA ticket for %s has been assigned to you.\n\nLocation: %s\nDates: %s\n",
+				name, conference.Title, conference.Location, conferenceDatesText(conference)),
+		})
+	})
+	return ticket, err
+}
+
+func (r *gormRepository) GetUser(id uint) (User, error) {
+	var user User
+	err := r.db.First(&user, id).Error
+	return user, err
+}
+
+func (r *gormRepository) FindUserByEmail(email string) (User, error) {
+	var user User
+	err := r.db.Where("email = ?", email).First(&user).Error
+	return user, err
+}
+
+func (r *gormRepository) CreateUser(user *User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *gormRepository) ListUsers() ([]User, error) {
+	var users []User
+	err := r.db.Find(&users).Error
+	return users, err
+}
+
+func (r *gormRepository) SetUserRole(id uint, role string) (User, error) {
+	user, err := r.GetUser(id)
+	if err != nil {
+		return user, err
+	}
+	if err := r.db.Model(&user).Update("role", role).Error; err != nil {
+		return user, err
+	}
+	return user, nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Schedule is a recurring departure of a vehicle on a route. The generator turns it
+// into dated trips with their seats a number of days ahead.
+type Schedule struct {
+	gorm.Model
+	RouteID         uint    `json:"routeId" gorm:"index"`
+	Route           Route   `json:"route"`
+	VehicleID       uint    `json:"vehicleId" gorm:"index"`
+	Vehicle         Vehicle `json:"vehicle"`
+	DepartureTime   string  `json:"departureTime"` // 15:04 in the route's time zone
+	ArrivalTime     string  `json:"arrivalTime"`   // the next day when before DepartureTime
+	Weekdays        string  `json:"weekdays"`      // e.g. "mon,tue,wed,thu,fri"; empty runs every day
+	StartDate       string  `json:"startDate"`     // 2006-01-02
+	EndDate         string  `json:"endDate"`       // empty runs until the schedule is deleted
+	ExcludeHolidays bool    `json:"excludeHolidays"`
+
+	// Pricing copied into every generated trip
+	Currency       string         `json:"currency" gorm:"size:3"`
+	TaxBasisPoints int            `json:"taxBasisPoints" gorm:"not null;default:0"`
+	Fares          []ScheduleFare `json:"fares"`
+	Free           bool           `json:"free" gorm:"not null;default:false"`
+}
+
+// ScheduleFare is the fare of a seat class on the trips of a schedule
+type ScheduleFare struct {
+	ID         uint   `json:"-" gorm:"primaryKey"`
+	ScheduleID uint   `json:"-" gorm:"uniqueIndex:idx_schedule_fare"`
+	SeatClass  string `json:"seatClass" gorm:"uniqueIndex:idx_schedule_fare;size:50"`
+	Price      int64  `json:"price"`
+}
+
+// Holiday is a day on which schedules with ExcludeHolidays do not run
+type Holiday struct {
+	gorm.Model
+	Date string `json:"date" gorm:"uniqueIndex;size:10"` // 2006-01-02
+	Name string `json:"name"`
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseWeekdays reads a comma-separated list of three-letter day names
+func parseWeekdays(list string) (map[time.Weekday]bool, error) {
+	days := map[time.Weekday]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		day, ok := weekdayNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q, use mon, tue, wed, thu, fri, sat or sun", name)
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+// validateSchedule checks the clock times, weekdays and date range of a schedule
+func validateSchedule(schedule Schedule) error {
+	if _, err := time.Parse(legacyClockLayout, schedule.DepartureTime); err != nil {
+		return errors.New("departureTime must be a time like 08:00")
+	}
+	if _, err := time.Parse(legacyClockLayout, schedule.ArrivalTime); err != nil {
+		return errors.New("arrivalTime must be a time like 09:30")
+	}
+	if schedule.ArrivalTime == schedule.DepartureTime {
+		return errors.New("arrivalTime must differ from departureTime")
+	}
+	if _, err := parseWeekdays(schedule.Weekdays); err != nil {
+		return err
+	}
+	if _, err := time.Parse(legacyDateLayout, schedule.StartDate); err != nil {
+		return errors.New("startDate must be a date like 2006-01-02")
+	}
+	if schedule.EndDate != "" {
+		if _, err := time.Parse(legacyDateLayout, schedule.EndDate); err != nil {
+			return errors.New("endDate must be a date like 2006-01-02")
+		}
+		if schedule.EndDate < schedule.StartDate {
+			return errors.New("endDate must not be before startDate")
+		}
+	}
+	return nil
+}
+
+// scheduledTrip returns the trip the schedule runs on the given day, and false when it
+// does not run that day
+func scheduledTrip(schedule Schedule, day time.Time, holidays map[string]bool) (Trip, bool) {
+	date := day.Format(legacyDateLayout)
+	if date < schedule.StartDate || (schedule.EndDate != "" && date > schedule.EndDate) {
+		return Trip{}, false
+	}
+	if schedule.ExcludeHolidays && holidays[date] {
+		return Trip{}, false
+	}
+	weekdays, err := parseWeekdays(schedule.Weekdays)
+	if err != nil || (len(weekdays) > 0 && !weekdays[day.Weekday()]) {
+		return Trip{}, false
+	}
+
+	departure, err := time.Parse(legacyClockLayout, schedule.DepartureTime)
+	if err != nil {
+		return Trip{}, false
+	}
+	arrival, err := time.Parse(legacyClockLayout, schedule.ArrivalTime)
+	if err != nil {
+		return Trip{}, false
+	}
+	loc := day.Location()
+	departsAt := time.Date(day.Year(), day.Month(), day.Day(), departure.Hour(), departure.Minute(), 0, 0, loc)
+	arrivesAt := time.Date(day.Year(), day.Month(), day.Day(), arrival.Hour(), arrival.Minute(), 0, 0, loc)
+	if !arrivesAt.After(departsAt) {
+		arrivesAt = time.Date(day.Year(), day.Month(), day.Day()+1, arrival.Hour(), arrival.Minute(), 0, 0, loc)
+	}
+
+	currency := schedule.Currency
+	if currency == "" {
+		currency = defaultCurrency()
+	}
+	fares := make([]TripFare, 0, len(schedule.Fares))
+	for _, fare := range schedule.Fares {
+		fares = append(fares, TripFare{SeatClass: fare.SeatClass, Price: fare.Price})
+	}
+
+	scheduleID := schedule.ID
+	return Trip{
+		RouteID:        schedule.RouteID,
+		VehicleID:      schedule.VehicleID,
+		ScheduleID:     &scheduleID,
+		DepartsAt:      departsAt,
+		ArrivesAt:      arrivesAt,
+		TotalSeats:     schedule.Vehicle.Capacity,
+		RemainingSeats: schedule.Vehicle.Capacity,
+		Currency:       currency,
+		TaxBasisPoints: schedule.TaxBasisPoints,
+		Fares:          fares,
+		Free:           schedule.Free,
+	}, true
+}
+
+// generateScheduledTrips creates the trips of every schedule that depart between now and
+// the given number of days ahead, with their seats. Trips that already exist are left
+// alone, so it is safe to run repeatedly and from several instances at once.
+func generateScheduledTrips(schedules ScheduleRepository, now time.Time, days int) (int, error) {
+	list, err := schedules.ListSchedules()
+	if err != nil {
+		return 0, err
+	}
+	holidayList, err := schedules.ListHolidays()
+	if err != nil {
+		return 0, err
+	}
+	holidays := make(map[string]bool, len(holidayList))
+	for _, h := range holidayList {
+		holidays[h.Date] = true
+	}
+
+	created := 0
+	for _, schedule := range list {
+		local := now.In(mustLocation(schedule.Route.TimeZone))
+		today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+		for i := 0; i <= days; i++ {
+			trip, ok := scheduledTrip(schedule, today.AddDate(0, 0, i), holidays)
+			if !ok || trip.DepartsAt.Before(now) {
+				continue
+			}
+			inserted, err := schedules.CreateScheduledTrip(&trip)
+			if err != nil {
+				return created, fmt.Errorf("schedule %d on %s: %w", schedule.ID, trip.DepartsAt.Format(legacyDateLayout), err)
+			}
+			if inserted {
+				created++
+			}
+		}
+	}
+	return created, nil
+}
+
+// tripGenerationDays is how far ahead trips are generated, set with TRIP_GENERATION_DAYS (default 30)
+func tripGenerationDays() int {
+	if v, err := strconv.Atoi(os.Getenv("TRIP_GENERATION_DAYS")); err == nil && v >= 0 {
+		return v
+	}
+	return 30
+}
+
+// startTripGenerator generates scheduled trips at startup and then on an interval set by
+// TRIP_GENERATION_INTERVAL_MINUTES (default 60)
+func startTripGenerator(schedules ScheduleRepository) {
+	interval := time.Hour
+	if v, err := strconv.Atoi(os.Getenv("TRIP_GENERATION_INTERVAL_MINUTES")); err == nil && v > 0 {
+		interval = time.Duration(v) * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		created, err := generateScheduledTrips(schedules, time.Now(), tripGenerationDays())
+		if err != nil {
+			log.Printf("Trip generation failed: %v", err)
+		} else if created > 0 {
+			log.Printf("Generated %d scheduled trips", created)
+		}
+		<-ticker.C
+	}
+}
+
+// Handler to list schedules
+func getAllSchedules(schedules ScheduleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := schedules.ListSchedules()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"schedules": list})
+	}
+}
+
+// Handler to create a schedule and generate its first trips
+func createSchedule(schedules ScheduleRepository, buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedule Schedule
+		if err := c.ShouldBindJSON(&schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if err := validateSchedule(schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		currency, fares, err := normalizeScheduleFares(schedule.Currency, schedule.TaxBasisPoints, schedule.Fares)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schedule.Currency, schedule.Fares = currency, fares
+
+		route, err := buses.GetRoute(schedule.RouteID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Route not found"})
+			return
+		}
+		vehicle, err := buses.GetVehicle(schedule.VehicleID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Vehicle not found"})
+			return
+		}
+
+		if err := schedules.CreateSchedule(&schedule); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+			return
+		}
+		schedule.Route = route
+		schedule.Vehicle = vehicle
+
+		created, err := generateScheduledTrips(schedules, time.Now(), tripGenerationDays())
+		if err != nil {
+			log.Printf("Trip generation failed: %v", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"schedule": schedule, "tripsCreated": created})
+	}
+}
+
+// normalizeScheduleFares checks the pricing of a schedule the way trip pricing is checked
+func normalizeScheduleFares(currency string, taxBasisPoints int, fares []ScheduleFare) (string, []ScheduleFare, error) {
+	currency, err := normalizePricing(currency, taxBasisPoints)
+	if err != nil {
+		return "", nil, err
+	}
+	tripFares := make([]TripFare, 0, len(fares))
+	for _, fare := range fares {
+		tripFares = append(tripFares, TripFare{SeatClass: fare.SeatClass, Price: fare.Price})
+	}
+	tripFares, err = normalizeFares(tripFares)
+	if err != nil {
+		return "", nil, err
+	}
+	result := make([]ScheduleFare, 0, len(tripFares))
+	for _, fare := range tripFares {
+		result = append(result, ScheduleFare{SeatClass: fare.SeatClass, Price: fare.Price})
+	}
+	return currency, result, nil
+}
+
+// Handler to replace the pricing of a schedule. Trips it already generated keep their
+// own pricing, which is changed with POST /api/trips/:id/pricing.
+func setSchedulePricing(schedules ScheduleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		var req SchedulePricingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		currency, fares, err := normalizeScheduleFares(req.Currency, req.TaxBasisPoints, req.Fares)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := schedules.GetSchedule(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		if err := schedules.SetSchedulePricing(id, currency, req.TaxBasisPoints, req.Free, fares); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule pricing"})
+			return
+		}
+		schedule, err := schedules.GetSchedule(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"schedule": schedule})
+	}
+}
+
+// Handler to delete a schedule. Trips it already generated keep running.
+func deleteSchedule(schedules ScheduleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := idParam(c, "id")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		if _, err := schedules.GetSchedule(id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+			return
+		}
+		if err := schedules.DeleteSchedule(id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+	}
+}
+
+// Handler to generate scheduled trips now, optionally further ahead with ?days=N
+func generateTripsHandler(schedules ScheduleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		days := tripGenerationDays()
+		if v := c.Query("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 366 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 0 and 366"})
+				return
+			}
+			days = n
+		}
+
+		created, err := generateScheduledTrips(schedules, time.Now(), days)
+		if err != nil {
+			log.Printf("Trip generation failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate trips", "tripsCreated": created})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tripsCreated": created, "days": days})
+	}
+}
+
+// Handler to list holidays
+func getAllHolidays(schedules ScheduleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := schedules.ListHolidays()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch holidays"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"holidays": list})
+	}
+}
+
+// Handler to add a holiday. Trips already generated for that day are not removed.
+func createHoliday(schedules ScheduleRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var holiday Holiday
+		if err := c.ShouldBindJSON(&holiday); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if _, err := time.Parse(legacyDateLayout, holiday.Date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be a date like 2006-01-02"})
+			return
+		}
+
+		if err := schedules.CreateHoliday(&holiday); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Holiday already exists"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"holiday": holiday})
+	}
+}
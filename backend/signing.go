@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"bookings/ticketsig"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	signingKey     ticketsig.Key
+	ticketVerifier *ticketsig.Verifier
+)
+
+// initTicketSigning loads ticket keys from TICKET_SIGNING_KEYS. TICKET_SIGNING_KID selects
+// the key used for new tickets; the others are kept so older tickets still verify.
+func initTicketSigning() {
+	keys, err := ticketsig.ParseKeys(os.Getenv("TICKET_SIGNING_KEYS"))
+	if err != nil {
+		log.Fatal("Failed to load ticket signing keys:", err)
+	}
+
+	if len(keys) == 0 {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal("Failed to generate ticket signing key:", err)
+		}
+		log.Println("TICKET_SIGNING_KEYS not set, using a temporary key; tickets will not verify after a restart")
+		keys = []ticketsig.Key{{ID: "dev", Alg: ticketsig.AlgEd25519, PrivateKey: priv, PublicKey: priv.Public().(ed25519.PublicKey)}}
+	}
+
+	ticketVerifier = ticketsig.NewVerifier(keys...)
+
+	activeKid := os.Getenv("TICKET_SIGNING_KID")
+	for _, k := range keys {
+		if k.CanSign() && (activeKid == "" || k.ID == activeKid) {
+			signingKey = k
+			return
+		}
+	}
+	log.Fatal("No usable ticket signing key found for TICKET_SIGNING_KID ", activeKid)
+}
+
+// busTicketToken signs the details of a bus ticket
+func busTicketToken(ticket BusTicket) (string, error) {
+	return ticketsig.Sign(signingKey, ticketsig.Claims{
+		TicketID:  ticket.ID,
+		Kind:      "bus",
+		EventID:   ticket.TripID,
+		Seat:      ticket.SeatNumber,
+		Passenger: ticket.FirstName + " " + ticket.LastName,
+		IssuedAt:  ticket.CreatedAt.Unix(),
+	})
+}
+
+// conferenceTicketToken signs the details of a single conference ticket
+func conferenceTicketToken(ticket ConferenceTicket, booking ConferenceBooking) (string, error) {
+	return ticketsig.Sign(signingKey, ticketsig.Claims{
+		TicketID:  ticket.ID,
+		Kind:      "conference",
+		EventID:   ticket.ConferenceID,
+		Passenger: attendeeName(ticket, booking),
+		IssuedAt:  ticket.CreatedAt.Unix(),
+		Version:   ticket.Version,
+	})
+}
+
+type VerifyTicketRequest struct {
+	Token string `json:"token"`
+}
+
+// Handler to verify a signed ticket token and report its current status
+func verifyTicketHandler(tickets TicketRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req VerifyTicketRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		claims, err := ticketVerifier.Verify(req.Token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"valid": false, "error": err.Error()})
+			return
+		}
+
+		// The signature proves authenticity; the database tells whether it is still usable
+		response := gin.H{"valid": true, "claims": claims}
+		if claims.Kind == "conference" {
+			ticket, err := tickets.GetConferenceTicket(claims.TicketID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ticket"})
+				return
+			}
+			if err != nil {
+				response["valid"] = false
+				response["error"] = "Ticket not found"
+			} else if claims.Version != ticket.Version {
+				// The ticket was reassigned after this token was issued
+				response["valid"] = false
+				response["error"] = "Ticket has been reissued"
+			} else {
+				response["revoked"] = ticket.Revoked
+				response["valid"] = !ticket.Revoked
+			}
+		}
+		if claims.Kind == "bus" {
+			ticket, err := tickets.GetBusTicket(claims.TicketID)
+			if err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ticket"})
+					return
+				}
+				response["valid"] = false
+				response["error"] = "Ticket not found"
+			} else {
+				response["used"] = ticket.Used
+				response["revoked"] = ticket.Revoked
+				response["valid"] = !ticket.Revoked
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Handler to publish the public keys devices need to verify tickets offline
+func getTicketKeys(c *gin.Context) {
+	var keys []gin.H
+	for _, k := range ticketVerifier.Keys() {
+		if k.Alg != ticketsig.AlgEd25519 {
+			continue
+		}
+		keys = append(keys, gin.H{
+			"kid":       k.ID,
+			"alg":       k.Alg,
+			"publicKey": base64.StdEncoding.EncodeToString(k.PublicKey),
+			"active":    k.ID == signingKey.ID,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReassignRetiresTicketToken(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	owner := signIn(t, db, "buyer@example.com", roleCustomer)
+	agent := signIn(t, db, "gate@example.com", roleAgent)
+
+	conference := Conference{
+		Title:            "Token Conf",
+		TimeZone:         "UTC",
+		StartsAt:         time.Now().Add(72 * time.Hour),
+		EndsAt:           time.Now().Add(96 * time.Hour),
+		Location:         "Hall C",
+		TotalTickets:     5,
+		RemainingTickets: 5,
+		Currency:         "EUR",
+		Free:             true,
+	}
+	if err := db.Create(&conference).Error; err != nil {
+		t.Fatalf("create conference: %v", err)
+	}
+	status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/conference/%d/book", conference.ID), ConferenceBooking{
+		FirstName: "Bea", LastName: "Buyer", Email: "buyer@example.com", Tickets: 1,
+	}, owner, nil)
+	if status != http.StatusOK {
+		t.Fatalf("book: status %d", status)
+	}
+
+	var ticket ConferenceTicket
+	if err := db.Where("conference_id = ?", conference.ID).First(&ticket).Error; err != nil {
+		t.Fatalf("load ticket: %v", err)
+	}
+	var booking ConferenceBooking
+	if err := db.First(&booking, ticket.ConferenceBookingID).Error; err != nil {
+		t.Fatalf("load booking: %v", err)
+	}
+	oldToken, err := conferenceTicketToken(ticket, booking)
+	if err != nil {
+		t.Fatalf("sign ticket: %v", err)
+	}
+
+	var assigned struct {
+		Ticket ConferenceTicket `json:"ticket"`
+	}
+	status = doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/conference/%d/tickets/%s/attendee", conference.ID, ticket.ID),
+		AttendeeRequest{Name: "Nora New"}, owner, &assigned)
+	if status != http.StatusOK {
+		t.Fatalf("reassign: status %d", status)
+	}
+	newToken, err := conferenceTicketToken(assigned.Ticket, booking)
+	if err != nil {
+		t.Fatalf("sign ticket: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"previous attendee", oldToken, false},
+		{"new attendee", newToken, true},
+	} {
+		var result struct {
+			Valid bool `json:"valid"`
+		}
+		status := doJSON(t, r, http.MethodPost, "/api/tickets/verify", VerifyTicketRequest{Token: tc.token}, agent, &result)
+		if status != http.StatusOK || result.Valid != tc.valid {
+			t.Errorf("%s: status %d, valid %v, want valid %v", tc.name, status, result.Valid, tc.valid)
+		}
+	}
+}
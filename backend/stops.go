@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RouteStop is a place a route calls at. Stops are numbered from 0 at the origin, and
+// segment i of a trip is the leg from stop i to stop i+1.
+type RouteStop struct {
+	gorm.Model
+	RouteID  uint   `json:"routeId" gorm:"uniqueIndex:idx_route_stop"`
+	Sequence int    `json:"sequence" gorm:"uniqueIndex:idx_route_stop"`
+	Name     string `json:"name" validate:"required,min=2,max=50"`
+}
+
+// Journey is the part of a trip a passenger travels, covering segments From up to To
+type Journey struct {
+	Boarding  RouteStop `json:"boardingStop"`
+	Alighting RouteStop `json:"alightingStop"`
+	From      int       `json:"-"`
+	To        int       `json:"-"`
+}
+
+// SeatAvailability is the state of a seat over a journey: booked when any of its
+// segments is booked, held when any is held, and available otherwise
+type SeatAvailability struct {
+	SeatNumber int    `json:"seatNumber"`
+	Status     string `json:"status"`
+}
+
+var errInvalidJourney = errors.New("alighting stop must come after the boarding stop on this route")
+
+// orderedStops sorts preloaded stops along the route
+func orderedStops(conn *gorm.DB) *gorm.DB {
+	return conn.Order("sequence")
+}
+
+// routeStops returns the stops of a route new routes get when none are given
+func routeStops(origin, destination string) []RouteStop {
+	return []RouteStop{{Sequence: 0, Name: origin}, {Sequence: 1, Name: destination}}
+}
+
+// segmentCount is the number of legs between the stops of a route
+func segmentCount(stops []RouteStop) int {
+	if len(stops) < 2 {
+		return 1
+	}
+	return len(stops) - 1
+}
+
+// tripJourney resolves the boarding and alighting stops of a trip whose route stops are
+// loaded. Zero IDs stand for the first and the last stop, so a journey without stops
+// covers the whole trip.
+func tripJourney(trip Trip, boardingStopID, alightingStopID uint) (Journey, error) {
+	stops := trip.Route.Stops
+	if len(stops) < 2 {
+		return Journey{
+			Boarding:  RouteStop{RouteID: trip.RouteID, Sequence: 0, Name: trip.Route.Origin},
+			Alighting: RouteStop{RouteID: trip.RouteID, Sequence: 1, Name: trip.Route.Destination},
+			From:      0,
+			To:        1,
+		}, nil
+	}
+
+	journey := Journey{From: -1, To: -1}
+	for i, stop := range stops {
+		if stop.ID == boardingStopID || (boardingStopID == 0 && i == 0) {
+			journey.Boarding, journey.From = stop, i
+		}
+		if stop.ID == alightingStopID || (alightingStopID == 0 && i == len(stops)-1) {
+			journey.Alighting, journey.To = stop, i
+		}
+	}
+	if journey.From < 0 || journey.To < 0 {
+		return Journey{}, errors.New("stop is not on this route")
+	}
+	if journey.To <= journey.From {
+		return Journey{}, errInvalidJourney
+	}
+	return journey, nil
+}
+
+// journeyParams reads the optional boardingStopId and alightingStopId query parameters
+func journeyParams(c *gin.Context) (uint, uint, bool) {
+	var ids [2]uint
+	for i, name := range []string{"boardingStopId", "alightingStopId"} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		ids[i] = uint(id)
+	}
+	return ids[0], ids[1], true
+}
+
+// seatAvailability folds the segment rows of each seat, ordered by seat number, into
+// the state of the seat over the journey they cover
+func seatAvailability(rows []BusSeat) []SeatAvailability {
+	var seats []SeatAvailability
+	for _, row := range rows {
+		if len(seats) == 0 || seats[len(seats)-1].SeatNumber != row.SeatNumber {
+			seats = append(seats, SeatAvailability{SeatNumber: row.SeatNumber, Status: "available"})
+		}
+		seat := &seats[len(seats)-1]
+		switch {
+		case row.SeatStatus == "booked":
+			seat.Status = "booked"
+		case row.SeatStatus == "held" && seat.Status == "available":
+			seat.Status = "held"
+		}
+	}
+	return seats
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// openDatabase connects to the database selected by DB_DRIVER:
+//
+//	mysql    (default) the server at DB_HOST:DB_PORT, see DB_USER, DB_PASS and DB_NAME
+//	postgres DATABASE_URL, or the server at DB_HOST:DB_PORT with DB_SSLMODE (default disable)
+//	sqlite   a local database file at DB_PATH (default bookings.db)
+//	memory   a private in-memory SQLite database that is lost when the process exits
+//
+// The SQLite drivers need no database server, so the whole API can run locally.
+func openDatabase() (*gorm.DB, error) {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "mysql"
+	}
+
+	var dialector gorm.Dialector
+	switch driver {
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
+			os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_NAME"))
+		dialector = mysql.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(postgresDSN())
+	case "sqlite":
+		path := os.Getenv("DB_PATH")
+		if path == "" {
+			path = "bookings.db"
+		}
+		dialector = sqlite.Open(path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	case "memory":
+		dialector = sqlite.Open(":memory:")
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", driver)
+	}
+
+	// TranslateError maps the drivers' unique violations to gorm.ErrDuplicatedKey
+	conn, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if driver == "sqlite" || driver == "memory" {
+		sqlDB, err := conn.DB()
+		if err != nil {
+			return nil, err
+		}
+		// SQLite allows one writer at a time, and every connection to ":memory:" is a
+		// separate database, so all queries share a single connection that is kept open.
+		// Row locks (SELECT ... FOR UPDATE) are dropped by the dialect; the single
+		// connection serializes the booking transactions instead.
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+	}
+	return conn, nil
+}
+
+// postgresDSN returns DATABASE_URL when set, otherwise a DSN built from the DB_* settings
+func postgresDSN() string {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		return dsn
+	}
+	port := os.Getenv("DB_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	sslMode := os.Getenv("DB_SSLMODE")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(os.Getenv("DB_USER"), os.Getenv("DB_PASS")),
+		Host:     net.JoinHostPort(os.Getenv("DB_HOST"), port),
+		Path:     "/" + os.Getenv("DB_NAME"),
+		RawQuery: url.Values{"sslmode": {sslMode}, "TimeZone": {"UTC"}}.Encode(),
+	}
+	return dsn.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openPostgresDB connects to the Postgres server at TEST_DATABASE_URL, which should
+// be a disposable database, and skips the test when the variable is not set. The CI
+// workflow sets it to a Postgres service.
+func openPostgresDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	conn, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return conn
+}
+
+// TestPostgresBusBooking runs the booking path against the Postgres server at
+// TEST_DATABASE_URL
+func TestPostgresBusBooking(t *testing.T) {
+	conn := openPostgresDB(t)
+	useTestDB(t, conn)
+	repos := newRepositories(conn)
+	r := newTestRouter(conn)
+
+	trip := createTestTrip(t, repos, fmt.Sprintf("Postgres %d", os.Getpid()), 4)
+	path := fmt.Sprintf("/api/bus/%d/book", trip.ID)
+
+	// Everyone races for seat 1; the row locks must let exactly one booking through
+	const clients = 8
+	var wg sync.WaitGroup
+	statuses := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses <- doJSON(t, r, http.MethodPost, path, BookingRequest{
+				FirstName:     "Pat",
+				LastName:      fmt.Sprintf("Racer%d", i),
+				Email:         fmt.Sprintf("racer%d@example.com", i),
+				SelectedSeats: []int{1},
+			}, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	booked := 0
+	for status := range statuses {
+		if status == http.StatusOK {
+			booked++
+		}
+	}
+	if booked != 1 {
+		t.Fatalf("seat 1 was booked %d times, want 1", booked)
+	}
+
+	var bookings []BusBooking
+	if err := conn.Where("trip_id = ?", trip.ID).Find(&bookings).Error; err != nil {
+		t.Fatalf("load bookings: %v", err)
+	}
+	if len(bookings) != 1 || bookings[0].Total != 2500 {
+		t.Fatalf("got bookings %+v, want one booking of 2500", bookings)
+	}
+	var stored Trip
+	if err := conn.First(&stored, trip.ID).Error; err != nil {
+		t.Fatalf("load trip: %v", err)
+	}
+	if stored.RemainingSeats != 3 {
+		t.Fatalf("remaining seats = %d, want 3", stored.RemainingSeats)
+	}
+
+	var tickets int64
+	conn.Model(&BusTicket{}).Where("trip_id = ? AND revoked = ?", trip.ID, false).Count(&tickets)
+	if tickets != 1 {
+		t.Fatalf("valid tickets = %d, want 1", tickets)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	_ "time/tzdata" // IANA zones for hosts and containers without a zoneinfo database
+)
+
+// Layouts of the free-form dates and times older clients send and older rows stored.
+// Migration 0003 converts stored rows with the legacy helpers below, so they must keep
+// reading every value they read today.
+var legacyDateTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var legacyClockLayouts = []string{
+	"15:04:05",
+	"15:04",
+}
+
+const (
+	legacyDateLayout  = "2006-01-02"
+	legacyClockLayout = "15:04"
+)
+
+// defaultTimeZone is used for trips and venues created without a time zone, set with APP_TIMEZONE
+func defaultTimeZone() string {
+	if tz := os.Getenv("APP_TIMEZONE"); tz != "" {
+		return tz
+	}
+	return "UTC"
+}
+
+// loadLocation resolves an IANA time zone name, falling back to the default zone
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = defaultTimeZone()
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// mustLocation is loadLocation for zones that were validated when they were stored.
+// A zone the host no longer knows is logged and read as UTC rather than failing the request.
+func mustLocation(name string) *time.Location {
+	loc, err := loadLocation(name)
+	if err != nil {
+		log.Printf("%v, using UTC", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// parseLegacyDateTime parses a date, a date and time, or a time on the given date in loc
+func parseLegacyDateTime(date, clock string, loc *time.Location) (time.Time, bool) {
+	if date != "" && clock != "" {
+		if d, err := time.ParseInLocation(legacyDateLayout, date, loc); err == nil {
+			for _, layout := range legacyClockLayouts {
+				if t, err := time.ParseInLocation(layout, clock, loc); err == nil {
+					return time.Date(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), true
+				}
+			}
+		}
+	}
+	for _, value := range []string{clock, date} {
+		if value == "" {
+			continue
+		}
+		for _, layout := range legacyDateTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// legacyTrip works out departure and arrival from the old date and time fields. An
+// arrival given as a time of day before the departure is taken to be the next day.
+func legacyTrip(date, departure, arrival string, loc *time.Location) (time.Time, time.Time, error) {
+	departsAt, ok := parseLegacyDateTime(date, departure, loc)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("tripDate and departureTime must be a date and a time")
+	}
+	arrivalDate := date
+	if arrivalDate == "" {
+		arrivalDate = departsAt.Format(legacyDateLayout)
+	}
+	arrivesAt, ok := parseLegacyDateTime(arrivalDate, arrival, loc)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("arrivalTime must be a time")
+	}
+	if arrivesAt.Before(departsAt) && len(arrival) <= len("15:04:05") {
+		arrivesAt = arrivesAt.AddDate(0, 0, 1)
+	}
+	return departsAt, arrivesAt, nil
+}
+
+// legacyConferenceDates turns the old start and end dates into an interval that ends
+// at midnight after the last conference day
+func legacyConferenceDates(start, end string, loc *time.Location) (time.Time, time.Time, error) {
+	startsAt, ok := parseLegacyDateTime(start, "", loc)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("startDate must be a date")
+	}
+	endsAt, ok := parseLegacyDateTime(end, "", loc)
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("endDate must be a date")
+	}
+	if len(end) == len(legacyDateLayout) {
+		endsAt = endsAt.AddDate(0, 0, 1)
+	}
+	return startsAt, endsAt, nil
+}
+
+// legacyEndDate is the last day of an interval whose end is exclusive
+func legacyEndDate(endsAt time.Time) string {
+	if endsAt.IsZero() {
+		return ""
+	}
+	return endsAt.Add(-time.Nanosecond).Format(legacyDateLayout)
+}
+
+// MarshalJSON writes the trip times as RFC3339 in the route's time zone, plus the
+// name/origin/destination/timeZone and tripDate/departureTime/arrivalTime fields of
+// buses for clients that still read them
+func (t Trip) MarshalJSON() ([]byte, error) {
+	type trip Trip
+	loc := mustLocation(t.Route.TimeZone)
+	out := trip(t)
+	out.DepartsAt = t.DepartsAt.In(loc)
+	out.ArrivesAt = t.ArrivesAt.In(loc)
+
+	return json.Marshal(struct {
+		trip
+		Name          string `json:"name"`
+		Origin        string `json:"origin"`
+		Destination   string `json:"destination"`
+		TimeZone      string `json:"timeZone"`
+		Date          string `json:"tripDate"`
+		DepartureTime string `json:"departureTime"`
+		ArrivalTime   string `json:"arrivalTime"`
+	}{
+		trip:          out,
+		Name:          t.Vehicle.Name,
+		Origin:        t.Route.Origin,
+		Destination:   t.Route.Destination,
+		TimeZone:      loc.String(),
+		Date:          out.DepartsAt.Format(legacyDateLayout),
+		DepartureTime: out.DepartsAt.Format(legacyClockLayout),
+		ArrivalTime:   out.ArrivesAt.Format(legacyClockLayout),
+	})
+}
+
+// UnmarshalJSON accepts departsAt/arrivesAt as RFC3339, or the old tripDate (or date),
+// departureTime and arrivalTime fields interpreted in the request's time zone
+func (r *BusRequest) UnmarshalJSON(data []byte) error {
+	type busRequest BusRequest
+	var in struct {
+		busRequest
+		Date          string `json:"tripDate"`
+		LegacyDate    string `json:"date"`
+		DepartureTime string `json:"departureTime"`
+		ArrivalTime   string `json:"arrivalTime"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*r = BusRequest(in.busRequest)
+
+	if r.DepartsAt.IsZero() && (in.Date != "" || in.LegacyDate != "" || in.DepartureTime != "") {
+		loc, err := loadLocation(r.TimeZone)
+		if err != nil {
+			return err
+		}
+		date := in.Date
+		if date == "" {
+			date = in.LegacyDate
+		}
+		r.DepartsAt, r.ArrivesAt, err = legacyTrip(date, in.DepartureTime, in.ArrivalTime, loc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes the conference interval as RFC3339 in the venue's time zone, plus
+// the old startDate/endDate fields
+func (c Conference) MarshalJSON() ([]byte, error) {
+	type conference Conference
+	loc := mustLocation(c.TimeZone)
+	out := conference(c)
+	out.TimeZone = loc.String()
+	out.StartsAt = c.StartsAt.In(loc)
+	out.EndsAt = c.EndsAt.In(loc)
+
+	return json.Marshal(struct {
+		conference
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	}{
+		conference: out,
+		StartDate:  out.StartsAt.Format(legacyDateLayout),
+		EndDate:    legacyEndDate(out.EndsAt),
+	})
+}
+
+// UnmarshalJSON accepts startsAt/endsAt as RFC3339, or the old startDate and endDate
+// fields as whole days in the venue time zone
+func (c *Conference) UnmarshalJSON(data []byte) error {
+	type conference Conference
+	var in struct {
+		conference
+		StartDate string `json:"startDate"`
+		EndDate   string `json:"endDate"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*c = Conference(in.conference)
+
+	if c.StartsAt.IsZero() && (in.StartDate != "" || in.EndDate != "") {
+		loc, err := loadLocation(c.TimeZone)
+		if err != nil {
+			return err
+		}
+		c.StartsAt, c.EndsAt, err = legacyConferenceDates(in.StartDate, in.EndDate, loc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON adds the old bookingDate/bookingTime fields derived from BookedAt
+func (b BusBooking) MarshalJSON() ([]byte, error) {
+	type busBooking BusBooking
+	return json.Marshal(struct {
+		busBooking
+		BookingDate string `json:"bookingDate"`
+		BookingTime string `json:"bookingTime"`
+	}{
+		busBooking:  busBooking(b),
+		BookingDate: b.BookedAt.Local().Format(legacyDateLayout),
+		BookingTime: b.BookedAt.Local().Format("15:04:05"),
+	})
+}
+
+// MarshalJSON adds the old bookingDate/bookingTime fields derived from BookedAt
+func (b ConferenceBooking) MarshalJSON() ([]byte, error) {
+	type conferenceBooking ConferenceBooking
+	return json.Marshal(struct {
+		conferenceBooking
+		BookingDate string `json:"bookingDate"`
+		BookingTime string `json:"bookingTime"`
+	}{
+		conferenceBooking: conferenceBooking(b),
+		BookingDate:       b.BookedAt.Local().Format(legacyDateLayout),
+		BookingTime:       b.BookedAt.Local().Format("15:04:05"),
+	})
+}
+
+// validateTripTimes checks that the trip arrives after it departs
+func validateTripTimes(trip Trip) error {
+	if trip.DepartsAt.IsZero() || trip.ArrivesAt.IsZero() {
+		return errors.New("departsAt and arrivesAt are required")
+	}
+	if !trip.ArrivesAt.After(trip.DepartsAt) {
+		return errors.New("arrivesAt must be after departsAt")
+	}
+	return nil
+}
+
+// validateConferenceTimes checks the time zone and that the conference ends after it starts
+func validateConferenceTimes(conference Conference) error {
+	if _, err := loadLocation(conference.TimeZone); err != nil {
+		return err
+	}
+	if conference.StartsAt.IsZero() || conference.EndsAt.IsZero() {
+		return errors.New("startsAt and endsAt are required")
+	}
+	if !conference.EndsAt.After(conference.StartsAt) {
+		return errors.New("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+// busDepartureText formats the departure for emails and tickets, in the route's time zone
+func busDepartureText(trip Trip) string {
+	return trip.DepartsAt.In(mustLocation(trip.Route.TimeZone)).Format("Mon 2 Jan 2006 15:04 MST")
+}
+
+// conferenceDatesText formats the conference days for emails and tickets
+func conferenceDatesText(conference Conference) string {
+	loc := mustLocation(conference.TimeZone)
+	return fmt.Sprintf("%s to %s", conference.StartsAt.In(loc).Format(legacyDateLayout), legacyEndDate(conference.EndsAt.In(loc)))
+}
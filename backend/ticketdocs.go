@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+// ticketDocument holds what is printed on a downloadable ticket, and who booked it
+type ticketDocument struct {
+	ID      string
+	Token   string
+	Title   string
+	Lines   []string
+	OwnerID *uint
+	Email   string
+}
+
+// lookupTicketDocument finds a bus or conference ticket by its UUID
+func lookupTicketDocument(repos Repositories, id string) (ticketDocument, error) {
+	ticket, err := repos.Tickets.GetBusTicket(id)
+	if err == nil {
+		return busTicketDocument(repos, ticket)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return ticketDocument{}, err
+	}
+
+	confTicket, err := repos.Tickets.GetConferenceTicket(id)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	return conferenceTicketDocument(repos, confTicket)
+}
+
+func busTicketDocument(repos Repositories, ticket BusTicket) (ticketDocument, error) {
+	trip, err := repos.Buses.GetTrip(ticket.TripID)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	booking, err := repos.Bookings.GetBusBooking(ticket.BusBookingID)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	token, err := busTicketToken(ticket)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	return ticketDocument{
+		ID:      ticket.ID,
+		Token:   token,
+		Title:   trip.Vehicle.Name,
+		OwnerID: booking.UserID,
+		Email:   booking.Email,
+		Lines: []string{
+			fmt.Sprintf("Passenger: %s %s", ticket.FirstName, ticket.LastName),
+			fmt.Sprintf("From %s to %s", booking.BoardingStop, booking.AlightingStop),
+			fmt.Sprintf("Departure: %s", busDepartureText(trip)),
+			fmt.Sprintf("Seat: %d", ticket.SeatNumber),
+		},
+	}, nil
+}
+
+func conferenceTicketDocument(repos Repositories, ticket ConferenceTicket) (ticketDocument, error) {
+	booking, err := repos.Bookings.GetConferenceBooking(ticket.ConferenceBookingID)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	conference, err := repos.Conferences.GetConference(ticket.ConferenceID)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	token, err := conferenceTicketToken(ticket, booking)
+	if err != nil {
+		return ticketDocument{}, err
+	}
+	return ticketDocument{
+		ID:      ticket.ID,
+		Token:   token,
+		Title:   conference.Title,
+		OwnerID: booking.UserID,
+		Email:   booking.Email,
+		Lines: []string{
+			fmt.Sprintf("Attendee: %s", attendeeName(ticket, booking)),
+			fmt.Sprintf("Location: %s", conference.Location),
+			fmt.Sprintf("Dates: %s", conferenceDatesText(conference)),
+			fmt.Sprintf("Booking reference: %d", booking.ID),
+		},
+	}, nil
+}
+
+// ticketQRCode encodes the signed ticket token as a PNG QR code, so it can be verified offline
+func ticketQRCode(doc ticketDocument) ([]byte, error) {
+	return qrcode.Encode(doc.Token, qrcode.Medium, 256)
+}
+
+// ticketPDF renders a one page ticket with its QR code
+func ticketPDF(doc ticketDocument) ([]byte, error) {
+	qr, err := ticketQRCode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := fpdf.New("P", "mm", "A5", "")
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, doc.Title, "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont("Helvetica", "", 12)
+	for _, line := range doc.Lines {
+		pdf.CellFormat(0, 8, line, "", 1, "L", false, 0, "")
+	}
+
+	opts := fpdf.ImageOptions{ImageType: "PNG"}
+	pdf.RegisterImageOptionsReader("qr", opts, bytes.NewReader(qr))
+	pdf.ImageOptions("qr", 34, pdf.GetY()+8, 80, 80, false, opts, 0, "")
+
+	pdf.SetY(pdf.GetY() + 92)
+	pdf.SetFont("Courier", "", 10)
+	pdf.CellFormat(0, 6, doc.ID, "", 1, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// messageAttachments rebuilds the PDF tickets for a queued outbox message
+func messageAttachments(repos Repositories, kind, reference string) ([]Attachment, error) {
+	var docs []ticketDocument
+	switch kind {
+	case "bus_tickets", "conference_tickets":
+		for _, id := range strings.Split(reference, ",") {
+			doc, err := lookupTicketDocument(repos, id)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, doc)
+		}
+	}
+
+	var attachments []Attachment
+	for _, doc := range docs {
+		data, err := ticketPDF(doc)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, Attachment{
+			Filename:    "ticket-" + doc.ID + ".pdf",
+			ContentType: "application/pdf",
+			Data:        data,
+		})
+	}
+	return attachments, nil
+}
+
+// ticketURLs returns the download links for the given ticket IDs
+func ticketURLs(ids []string) []string {
+	urls := make([]string, 0, len(ids))
+	for _, id := range ids {
+		urls = append(urls, "/api/tickets/"+id+"/pdf")
+	}
+	return urls
+}
+
+// Handler to download a ticket as PDF
+func getTicketPDF(repos Repositories) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		doc, err := lookupTicketDocument(repos, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+			return
+		}
+		if !canManageBooking(c, doc.OwnerID, doc.Email) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot view this ticket"})
+			return
+		}
+
+		data, err := ticketPDF(doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate ticket"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="ticket-%s.pdf"`, doc.ID))
+		c.Data(http.StatusOK, "application/pdf", data)
+	}
+}
+
+// Handler to get the QR code of a ticket
+func getTicketQRCode(repos Repositories) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		doc, err := lookupTicketDocument(repos, c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+			return
+		}
+		if !canManageBooking(c, doc.OwnerID, doc.Email) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot view this ticket"})
+			return
+		}
+
+		data, err := ticketQRCode(doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+			return
+		}
+		c.Data(http.StatusOK, "image/png", data)
+	}
+}
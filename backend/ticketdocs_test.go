@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTicketPDFRejectsBookingIDs(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+
+	booking := ConferenceBooking{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Tickets: 1, ConferenceID: 1, BookedAt: time.Now()}
+	if err := db.Create(&booking).Error; err != nil {
+		t.Fatalf("create booking: %v", err)
+	}
+	// Even staff, who may see every ticket, cannot fetch one by booking ID
+	admin := signIn(t, db, "admin@example.com", roleAdmin)
+	path := fmt.Sprintf("/api/tickets/%d/pdf", booking.ID)
+	if status := doJSON(t, r, http.MethodGet, path, nil, admin, nil); status != http.StatusNotFound {
+		t.Fatalf("GET %s: status %d, want 404", path, status)
+	}
+}
+
+func TestConferenceTicketBackfill(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	// Go back to the schema before the backfill
+	steps := 0
+	for i, m := range migrations {
+		if m.Version == "0009" {
+			steps = len(migrations) - i
+		}
+	}
+	if _, err := migrateDown(db, steps); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	conference := Conference{Title: "Legacy Conf", Location: "Hall B", TimeZone: "UTC", TotalTickets: 10, RemainingTickets: 7}
+	if err := db.Omit("Tiers", "Free").Create(&conference).Error; err != nil {
+		t.Fatalf("create conference: %v", err)
+	}
+	booking := ConferenceBooking{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Tickets: 3, ConferenceID: conference.ID, BookedAt: time.Now()}
+	if err := db.Create(&booking).Error; err != nil {
+		t.Fatalf("create booking: %v", err)
+	}
+	if _, err := migrateUp(db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	var tickets []ConferenceTicket
+	db.Where("conference_booking_id = ?", booking.ID).Find(&tickets)
+	if len(tickets) != 3 {
+		t.Fatalf("backfilled %d tickets, want 3", len(tickets))
+	}
+	if _, err := lookupTicketDocument(newRepositories(db), tickets[0].ID); err != nil {
+		t.Fatalf("lookup backfilled ticket: %v", err)
+	}
+}
+
+func TestTicketPDFRequiresOwner(t *testing.T) {
+	db := openMemoryDB(t)
+	useTestDB(t, db)
+	r := newTestRouter(db)
+	trip := createTestTrip(t, newRepositories(db), "Owner Coach", 4)
+	owner := signIn(t, db, "owner@example.com", roleCustomer)
+	stranger := signIn(t, db, "stranger@example.com", roleCustomer)
+
+	var booked struct {
+		TicketIDs []string `json:"ticketIDs"`
+	}
+	status := doJSON(t, r, http.MethodPost, fmt.Sprintf("/api/bus/%d/book", trip.ID), BookingRequest{
+		FirstName: "Olive", LastName: "Owner", Email: "owner@example.com", SelectedSeats: []int{2},
+	}, owner, &booked)
+	if status != http.StatusOK || len(booked.TicketIDs) != 1 {
+		t.Fatalf("book: status %d, tickets %v", status, booked.TicketIDs)
+	}
+	ticketID := booked.TicketIDs[0]
+
+	guestToken, err := issueGuestToken("owner@example.com")
+	if err != nil {
+		t.Fatalf("issue guest token: %v", err)
+	}
+	otherGuestToken, err := issueGuestToken("stranger@example.com")
+	if err != nil {
+		t.Fatalf("issue guest token: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"anonymous pdf", "/api/tickets/" + ticketID + "/pdf", nil, http.StatusUnauthorized},
+		{"anonymous qr", "/api/tickets/" + ticketID + "/qr.png", nil, http.StatusUnauthorized},
+		{"other customer", "/api/tickets/" + ticketID + "/pdf", stranger, http.StatusForbidden},
+		{"owner pdf", "/api/tickets/" + ticketID + "/pdf", owner, http.StatusOK},
+		{"owner qr", "/api/tickets/" + ticketID + "/qr.png", owner, http.StatusOK},
+		{"guest link", "/api/guest/tickets/" + ticketID + "/pdf", http.Header{"X-Guest-Token": {guestToken}}, http.StatusOK},
+		{"other guest link", "/api/guest/tickets/" + ticketID + "/pdf", http.Header{"X-Guest-Token": {otherGuestToken}}, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		for k, v := range tc.header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CheckInRequest struct {
+	TripID   uint   `json:"tripId"`
+	BusID    uint   `json:"busId"` // sent by scanners that predate trips
+	DeviceID string `json:"deviceId"`
+}
+
+// Handler to check a passenger in by scanning their bus ticket
+func checkInTicketHandler(tickets TicketRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CheckInRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if req.TripID == 0 {
+			req.TripID = req.BusID
+		}
+		if req.TripID == 0 || req.DeviceID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tripId and deviceId are required"})
+			return
+		}
+
+		ticket, checkedIn, err := tickets.CheckInBusTicket(c.Param("id"), req.TripID, req.DeviceID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check in ticket"})
+			return
+		}
+
+		if !checkedIn {
+			switch {
+			case ticket.TripID != req.TripID:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Ticket is not valid for this bus", "tripId": ticket.TripID})
+			case ticket.Revoked:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Ticket has been cancelled"})
+			default:
+				c.JSON(http.StatusConflict, gin.H{
+					"error":       "Ticket already checked in",
+					"usedAt":      ticket.UsedAt,
+					"checkedInBy": ticket.CheckedInBy,
+				})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Checked in",
+			"seatNumber": ticket.SeatNumber,
+			"firstName":  ticket.FirstName,
+			"lastName":   ticket.LastName,
+			"usedAt":     ticket.UsedAt,
+		})
+	}
+}
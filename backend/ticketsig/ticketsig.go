@@ -0,0 +1,234 @@
+// Package ticketsig issues and verifies signed ticket tokens.
+//
+// A token has the form header.claims.signature, each part base64url encoded
+// without padding. The header names the algorithm and the key ID, so keys can
+// be rotated while older tickets stay verifiable. Verification needs only the
+// public key (Ed25519) or the shared secret (HMAC), so it works offline.
+package ticketsig
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	AlgEd25519 = "EdDSA"
+	AlgHMAC    = "HS256"
+)
+
+var (
+	ErrMalformed    = errors.New("ticketsig: malformed token")
+	ErrUnknownKey   = errors.New("ticketsig: unknown key id")
+	ErrBadSignature = errors.New("ticketsig: invalid signature")
+)
+
+// Claims is the ticket data covered by the signature
+type Claims struct {
+	TicketID  string `json:"tid"`
+	Kind      string `json:"kind"` // "bus" or "conference"
+	EventID   uint   `json:"eid"`  // bus or conference ID
+	Seat      int    `json:"seat,omitempty"`
+	Passenger string `json:"name"`
+	IssuedAt  int64  `json:"iat"`
+	Version   int    `json:"ver,omitempty"` // issue of the ticket; a reissued ticket retires older tokens
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Key is a signing or verification key identified by a key ID
+type Key struct {
+	ID         string
+	Alg        string
+	PrivateKey ed25519.PrivateKey // Ed25519 signing only
+	PublicKey  ed25519.PublicKey
+	Secret     []byte // HMAC
+}
+
+// CanSign reports whether the key holds private material
+func (k Key) CanSign() bool {
+	switch k.Alg {
+	case AlgEd25519:
+		return len(k.PrivateKey) == ed25519.PrivateKeySize
+	case AlgHMAC:
+		return len(k.Secret) > 0
+	}
+	return false
+}
+
+// Sign encodes the claims into a token signed with key
+func Sign(key Key, claims Claims) (string, error) {
+	if !key.CanSign() {
+		return "", fmt.Errorf("ticketsig: key %q cannot sign", key.ID)
+	}
+
+	h, err := json.Marshal(header{Alg: key.Alg, Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(h) + "." + encode(c)
+	var sig []byte
+	switch key.Alg {
+	case AlgEd25519:
+		sig = ed25519.Sign(key.PrivateKey, []byte(signingInput))
+	case AlgHMAC:
+		sig = hmacSum(key.Secret, signingInput)
+	}
+	return signingInput + "." + encode(sig), nil
+}
+
+// Verifier checks tokens against a set of keys
+type Verifier struct {
+	keys map[string]Key
+}
+
+// NewVerifier returns a verifier that accepts tokens signed by any of keys
+func NewVerifier(keys ...Key) *Verifier {
+	v := &Verifier{keys: make(map[string]Key)}
+	for _, k := range keys {
+		v.AddKey(k)
+	}
+	return v
+}
+
+// AddKey registers a key, replacing any key with the same ID
+func (v *Verifier) AddKey(k Key) {
+	if k.Alg == AlgEd25519 && k.PublicKey == nil && k.PrivateKey != nil {
+		k.PublicKey = k.PrivateKey.Public().(ed25519.PublicKey)
+	}
+	v.keys[k.ID] = k
+}
+
+// Keys returns the registered keys
+func (v *Verifier) Keys() []Key {
+	keys := make([]Key, 0, len(v.keys))
+	for _, k := range v.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Verify checks the token signature and returns its claims
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+
+	var h header
+	if err := decodeJSON(parts[0], &h); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+
+	key, ok := v.keys[h.Kid]
+	if !ok {
+		return Claims{}, ErrUnknownKey
+	}
+	if key.Alg != h.Alg {
+		return Claims{}, ErrBadSignature
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	switch key.Alg {
+	case AlgEd25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(key.PublicKey, []byte(signingInput), sig) {
+			return Claims{}, ErrBadSignature
+		}
+	case AlgHMAC:
+		if !hmac.Equal(sig, hmacSum(key.Secret, signingInput)) {
+			return Claims{}, ErrBadSignature
+		}
+	default:
+		return Claims{}, ErrBadSignature
+	}
+
+	var claims Claims
+	if err := decodeJSON(parts[1], &claims); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	return claims, nil
+}
+
+// ParseKeys reads a comma separated key list of the form kid:type:base64, where
+// type is ed25519 (64 byte private key or 32 byte seed), ed25519-pub or hmac.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("ticketsig: invalid key entry %q", entry)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("ticketsig: key %q: %w", parts[0], err)
+		}
+
+		key := Key{ID: parts[0]}
+		switch parts[1] {
+		case "ed25519":
+			key.Alg = AlgEd25519
+			switch len(raw) {
+			case ed25519.SeedSize:
+				key.PrivateKey = ed25519.NewKeyFromSeed(raw)
+			case ed25519.PrivateKeySize:
+				key.PrivateKey = ed25519.PrivateKey(raw)
+			default:
+				return nil, fmt.Errorf("ticketsig: key %q has invalid ed25519 private key size", parts[0])
+			}
+			key.PublicKey = key.PrivateKey.Public().(ed25519.PublicKey)
+		case "ed25519-pub":
+			if len(raw) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("ticketsig: key %q has invalid ed25519 public key size", parts[0])
+			}
+			key.Alg = AlgEd25519
+			key.PublicKey = ed25519.PublicKey(raw)
+		case "hmac":
+			key.Alg = AlgHMAC
+			key.Secret = raw
+		default:
+			return nil, fmt.Errorf("ticketsig: key %q has unknown type %q", parts[0], parts[1])
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJSON(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func hmacSum(secret []byte, input string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
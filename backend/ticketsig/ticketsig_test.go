@@ -0,0 +1,113 @@
+package ticketsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newEd25519Key(t *testing.T, id string) Key {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return Key{ID: id, Alg: AlgEd25519, PrivateKey: priv, PublicKey: pub}
+}
+
+var testClaims = Claims{
+	TicketID:  "0b5c7c1e-7d55-4d1e-9a55-0b1f6f0a2a10",
+	Kind:      "bus",
+	EventID:   7,
+	Seat:      12,
+	Passenger: "Ada Lovelace",
+	IssuedAt:  1700000000,
+	Version:   2,
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	for _, key := range []Key{
+		newEd25519Key(t, "ed"),
+		{ID: "mac", Alg: AlgHMAC, Secret: []byte("shared secret")},
+	} {
+		token, err := Sign(key, testClaims)
+		if err != nil {
+			t.Fatalf("%s: sign: %v", key.Alg, err)
+		}
+		claims, err := NewVerifier(key).Verify(token)
+		if err != nil {
+			t.Fatalf("%s: verify: %v", key.Alg, err)
+		}
+		if claims != testClaims {
+			t.Errorf("%s: got claims %+v, want %+v", key.Alg, claims, testClaims)
+		}
+	}
+}
+
+func TestVerifyRejectsTamperedClaims(t *testing.T) {
+	key := newEd25519Key(t, "ed")
+	token, err := Sign(key, testClaims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// Swap in claims for another seat, keeping the original header and signature
+	forged := testClaims
+	forged.Seat = 1
+	other, err := Sign(newEd25519Key(t, "ed"), forged)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Split(other, ".")[1]
+
+	_, err = NewVerifier(key).Verify(strings.Join(parts, "."))
+	if !errors.Is(err, ErrBadSignature) {
+		t.Errorf("got %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestVerifyRejectsUnknownKey(t *testing.T) {
+	token, err := Sign(newEd25519Key(t, "retired"), testClaims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	_, err = NewVerifier(newEd25519Key(t, "current")).Verify(token)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newEd25519Key(t, "2024")
+	newKey := newEd25519Key(t, "2025")
+	oldToken, err := Sign(oldKey, testClaims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	newToken, err := Sign(newKey, testClaims)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// After rotation only the public half of the old key is kept
+	spec := "2024:ed25519-pub:" + base64.StdEncoding.EncodeToString(oldKey.PublicKey) +
+		",2025:ed25519:" + base64.StdEncoding.EncodeToString(newKey.PrivateKey.Seed())
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	if keys[0].CanSign() || !keys[1].CanSign() {
+		t.Fatalf("retired key can sign: %v, active key can sign: %v", keys[0].CanSign(), keys[1].CanSign())
+	}
+
+	verifier := NewVerifier(keys...)
+	for name, token := range map[string]string{"old key": oldToken, "new key": newToken} {
+		if _, err := verifier.Verify(token); err != nil {
+			t.Errorf("%s: verify: %v", name, err)
+		}
+	}
+}
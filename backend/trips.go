@@ -0,0 +1,409 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Route is a line the operator runs between two places, calling at its stops in order
+type Route struct {
+	gorm.Model
+	Name        string      `json:"name"`
+	Origin      string      `json:"origin" validate:"required,min=3,max=50"`
+	Destination string      `json:"destination" validate:"required,min=3,max=50"`
+	TimeZone    string      `json:"timeZone" validate:"timezone"`
+	Stops       []RouteStop `json:"stops"`
+}
+
+// Vehicle is a bus of the fleet; its capacity sets the seats of every trip it drives.
+// Vehicles built to a seat layout have one seat per seat of the layout.
+type Vehicle struct {
+	gorm.Model
+	Name         string `json:"name" validate:"required,min=3,max=50"`
+	Registration string `json:"registration"`
+	Capacity     int    `json:"capacity" validate:"required,min=1"`
+	SeatLayoutID *uint  `json:"seatLayoutId" gorm:"index"`
+}
+
+// Trip is one dated departure of a vehicle on a route. Seats are sold per trip, so the
+// API still calls it a bus and serves it under /api/bus/:id.
+type Trip struct {
+	gorm.Model
+	RouteID        uint       `json:"routeId" validate:"required" gorm:"index"`
+	Route          Route      `json:"route"`
+	VehicleID      uint       `json:"vehicleId" validate:"required" gorm:"index"`
+	Vehicle        Vehicle    `json:"vehicle"`
+	ScheduleID     *uint      `json:"scheduleId" gorm:"uniqueIndex:idx_trip_schedule"`
+	DepartsAt      time.Time  `json:"departsAt" validate:"required" gorm:"uniqueIndex:idx_trip_schedule"`
+	ArrivesAt      time.Time  `json:"arrivesAt" validate:"required,gtfield=DepartsAt"`
+	TotalSeats     int        `json:"totalSeats"`
+	RemainingSeats int        `json:"remainingSeats"`
+	Currency       string     `json:"currency" gorm:"size:3"`
+	TaxBasisPoints int        `json:"taxBasisPoints" gorm:"not null;default:0"`
+	Fares          []TripFare `json:"fares"`
+	Free           bool       `json:"free" gorm:"not null;default:false"` // bookable without fares
+}
+
+// TripRequest is the body of POST /api/trips
+type TripRequest struct {
+	RouteID        uint       `json:"routeId"`
+	VehicleID      uint       `json:"vehicleId"`
+	DepartsAt      time.Time  `json:"departsAt"`
+	ArrivesAt      time.Time  `json:"arrivesAt"`
+	Currency       string     `json:"currency"`
+	TaxBasisPoints int        `json:"taxBasisPoints"`
+	Fares          []TripFare `json:"fares"`
+	Free           bool       `json:"free"`
+}
+
+// BusRequest is the body of POST /api/bus, which creates a trip together with its
+// route and vehicle the way buses were created before trips were split out
+type BusRequest struct {
+	Name           string     `json:"name"`
+	Origin         string     `json:"origin"`
+	Destination    string     `json:"destination"`
+	TimeZone       string     `json:"timeZone"`
+	DepartsAt      time.Time  `json:"departsAt"`
+	ArrivesAt      time.Time  `json:"arrivesAt"`
+	TotalSeats     int        `json:"totalSeats"`
+	SeatLayoutID   *uint      `json:"seatLayoutId"` // sets TotalSeats when given
+	Currency       string     `json:"currency"`
+	TaxBasisPoints int        `json:"taxBasisPoints"`
+	Fares          []TripFare `json:"fares"`
+	Free           bool       `json:"free"`
+}
+
+// withTripDetails loads the route with its stops, the vehicle and the fares along with trips
+func withTripDetails(conn *gorm.DB) *gorm.DB {
+	return conn.Preload("Route.Stops", orderedStops).Preload("Vehicle").Preload("Fares")
+}
+
+// tripSeats returns the seat inventory of a new trip: each of its TotalSeats seats is
+// sold per segment, so it gets one available row for every segment of the route
+func tripSeats(trip Trip, segments int) []BusSeat {
+	var seats []BusSeat
+	for i := 1; i <= trip.TotalSeats; i++ {
+		for segment := 0; segment < segments; segment++ {
+			seats = append(seats, BusSeat{
+				TripID:     trip.ID,
+				SeatNumber: i,
+				Segment:    segment,
+				SeatStatus: "available",
+			})
+		}
+	}
+	return seats
+}
+
+// routeName is the name given to routes created without one
+func routeName(origin, destination string) string {
+	return origin + " - " + destination
+}
+
+// Handler to list routes
+func getAllRoutes(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		routes, err := buses.ListRoutes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch routes"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"routes": routes})
+	}
+}
+
+// Handler to create a route
+func createRoute(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var route Route
+		if err := c.ShouldBindJSON(&route); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		// The first and last stops are the origin and destination; a route given
+		// without stops calls only at its origin and destination
+		if len(route.Stops) == 0 {
+			route.Stops = routeStops(route.Origin, route.Destination)
+		}
+		if len(route.Stops) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A route needs at least two stops"})
+			return
+		}
+		for i := range route.Stops {
+			route.Stops[i].Sequence = i
+			route.Stops[i].Name = strings.TrimSpace(route.Stops[i].Name)
+			if route.Stops[i].Name == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Origin, destination and stop names are required"})
+				return
+			}
+		}
+		route.Origin = route.Stops[0].Name
+		route.Destination = route.Stops[len(route.Stops)-1].Name
+		if _, err := loadLocation(route.TimeZone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if route.TimeZone == "" {
+			route.TimeZone = defaultTimeZone()
+		}
+		if route.Name == "" {
+			route.Name = routeName(route.Origin, route.Destination)
+		}
+
+		if err := buses.CreateRoute(&route); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create route"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"route": route})
+	}
+}
+
+// Handler to list vehicles
+func getAllVehicles(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vehicles, err := buses.ListVehicles()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicles"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"vehicles": vehicles})
+	}
+}
+
+// Handler to add a vehicle to the fleet
+func createVehicle(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var vehicle Vehicle
+		if err := c.ShouldBindJSON(&vehicle); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if vehicle.SeatLayoutID != nil {
+			layout, err := buses.GetSeatLayout(*vehicle.SeatLayoutID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Seat layout not found"})
+				return
+			}
+			vehicle.Capacity = len(layout.Seats)
+		}
+		if strings.TrimSpace(vehicle.Name) == "" || vehicle.Capacity <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name and capacity are required"})
+			return
+		}
+
+		if err := buses.CreateVehicle(&vehicle); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vehicle"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"vehicle": vehicle})
+	}
+}
+
+// Handler to list trips
+func getAllTrips(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		trips, err := buses.ListTrips()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trips"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"trips": trips})
+	}
+}
+
+// Handler to schedule a vehicle on a route, creating the seat inventory of the trip
+func createTrip(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TripRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+
+		route, err := buses.GetRoute(req.RouteID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Route not found"})
+			return
+		}
+		vehicle, err := buses.GetVehicle(req.VehicleID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Vehicle not found"})
+			return
+		}
+		currency, err := normalizePricing(req.Currency, req.TaxBasisPoints)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		fares, err := normalizeFares(req.Fares)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		trip := Trip{
+			RouteID:        route.ID,
+			Route:          route,
+			VehicleID:      vehicle.ID,
+			Vehicle:        vehicle,
+			DepartsAt:      req.DepartsAt,
+			ArrivesAt:      req.ArrivesAt,
+			TotalSeats:     vehicle.Capacity,
+			RemainingSeats: vehicle.Capacity,
+			Currency:       currency,
+			TaxBasisPoints: req.TaxBasisPoints,
+			Fares:          fares,
+			Free:           req.Free,
+		}
+		if err := validateTripTimes(trip); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := buses.CreateTrip(&trip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trip"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"trip": trip})
+	}
+}
+
+// Function to create a new bus, i.e. a trip on a route and vehicle that are created
+// on first use and reused after that
+func createBus(buses BusRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+		if req.SeatLayoutID != nil {
+			layout, err := buses.GetSeatLayout(*req.SeatLayoutID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Seat layout not found"})
+				return
+			}
+			req.TotalSeats = len(layout.Seats)
+		}
+		if req.Name == "" || req.Origin == "" || req.Destination == "" || req.TotalSeats <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name, origin, destination and totalSeats are required"})
+			return
+		}
+		if _, err := loadLocation(req.TimeZone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.TimeZone == "" {
+			req.TimeZone = defaultTimeZone()
+		}
+
+		route := Route{
+			Name:        routeName(req.Origin, req.Destination),
+			Origin:      req.Origin,
+			Destination: req.Destination,
+			TimeZone:    req.TimeZone,
+			Stops:       routeStops(req.Origin, req.Destination),
+		}
+		currency, err := normalizePricing(req.Currency, req.TaxBasisPoints)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		fares, err := normalizeFares(req.Fares)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		vehicle := Vehicle{Name: req.Name, Capacity: req.TotalSeats, SeatLayoutID: req.SeatLayoutID}
+		trip := Trip{
+			DepartsAt:      req.DepartsAt,
+			ArrivesAt:      req.ArrivesAt,
+			TotalSeats:     req.TotalSeats,
+			RemainingSeats: req.TotalSeats,
+			Currency:       currency,
+			TaxBasisPoints: req.TaxBasisPoints,
+			Fares:          fares,
+			Free:           req.Free,
+		}
+		if err := validateTripTimes(trip); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := buses.FindOrCreateRoute(&route); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create route"})
+			return
+		}
+		if err := buses.FindOrCreateVehicle(&vehicle); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vehicle"})
+			return
+		}
+		trip.RouteID, trip.Route = route.ID, route
+		trip.VehicleID, trip.Vehicle = vehicle.ID, vehicle
+
+		// Create the trip with its seats
+		if err := buses.CreateTrip(&trip); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bus"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"bus": trip})
+	}
+}
+
+// Function to set up the initial bus if it doesn't exist
+func setupInitialBus(conn *gorm.DB) {
+	var vehicle Vehicle
+	result := conn.Where("name = ?", busName).First(&vehicle)
+	if result.Error == nil || !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return
+	}
+
+	loc := mustLocation("")
+	route := Route{
+		Name:        routeName("City A", "City B"),
+		Origin:      "City A",
+		Destination: "City B",
+		TimeZone:    defaultTimeZone(),
+		Stops:       routeStops("City A", "City B"),
+	}
+	vehicle = Vehicle{Name: busName, Capacity: 50}
+	trip := Trip{
+		DepartsAt:      time.Date(2023, 10, 1, 10, 0, 0, 0, loc),
+		ArrivesAt:      time.Date(2023, 10, 1, 11, 0, 0, 0, loc),
+		TotalSeats:     vehicle.Capacity,
+		RemainingSeats: vehicle.Capacity,
+		Currency:       defaultCurrency(),
+		Free:           true,
+	}
+
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&route).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&vehicle).Error; err != nil {
+			return err
+		}
+		trip.RouteID = route.ID
+		trip.VehicleID = vehicle.ID
+		if err := tx.Omit(clause.Associations).Create(&trip).Error; err != nil {
+			return err
+		}
+		seats := tripSeats(trip, segmentCount(route.Stops))
+		return tx.Create(&seats).Error
+	})
+	if err != nil {
+		log.Fatal("Failed to create bus:", err)
+	}
+
+	fmt.Println("A new bus with seats has been created")
+}
@@ -4,6 +4,7 @@ import Dashboard from './components/Dashboard/Dashboard';
 import BusBooking from './components/BusBooking/BusBooking';
 import ConferenceBooking from './components/ConferenceBooking/ConferenceBooking';
 import RecentBookings from './components/RecentBookings';
+import GuestBookings from './components/GuestBookings';
 import './App.css';
 
 function App() {
@@ -16,6 +17,7 @@ function App() {
           <Route path="/bus" element={<BusBooking />} />
           <Route path="/conference" element={<ConferenceBooking />} />
           <Route path="/recent" element={<RecentBookings />} />
+          <Route path="/my-bookings" element={<GuestBookings />} />
         </Routes>
       </div>
     </div>
@@ -1,10 +1,11 @@
-import React, { useState, useEffect } from "react";
+import React, { useState, useEffect, useRef } from "react";
 import axios from "axios";
 import BookingForm from "./BookingForm";
 import RecentBookings from "../RecentBookings";
 import BusPanel from "./BusPanel";
 import CreateBusForm from "./CreateBusForm";
 import SeatMap from "./SeatMap";
+import PriceQuote from "../PriceQuote";
 import "./BusBooking.css";
 import { ToastContainer, toast } from "react-toastify";
 import "react-toastify/dist/ReactToastify.css";
@@ -19,6 +20,7 @@ const BusBooking = () => {
     totalSeats: 0,
     remaining: 0,
     seatLayout: [],
+    layout: null,
   });
   const [busBookings, setBusBookings] = useState([]);
   const [formData, setFormData] = useState({
@@ -27,6 +29,13 @@ const BusBooking = () => {
     email: "",
     selectedSeats: [], 
   });
+  const [holdToken, setHoldToken] = useState("");
+  // Seats are sold per leg, so availability depends on where the passenger boards and alights
+  const [stops, setStops] = useState([]);
+  const [journey, setJourney] = useState({ boardingStopId: 0, alightingStopId: 0 });
+  const [quote, setQuote] = useState(null);
+  // Reused when the same booking is retried, so the server never books it twice
+  const idempotencyKey = useRef(crypto.randomUUID());
   console.log(formData);
   console.log(formData.selectedSeats);
   const [errors, setErrors] = useState({});
@@ -38,6 +47,14 @@ const BusBooking = () => {
     });
   }, []);
 
+  const seatsUrl = (stopIds = journey) => {
+    const params = new URLSearchParams();
+    if (stopIds.boardingStopId) params.set("boardingStopId", stopIds.boardingStopId);
+    if (stopIds.alightingStopId) params.set("alightingStopId", stopIds.alightingStopId);
+    const query = params.toString();
+    return `http://localhost:8085/api/bus/${selectedBus.ID}/seats${query ? `?${query}` : ""}`;
+  };
+
   useEffect(() => {
     if (!selectedBus) return;
 
@@ -51,11 +68,18 @@ const BusBooking = () => {
         ]);
 
         const bus = busRes.data.bus;
+        const routeStops = bus.route?.stops || [];
+        setStops(routeStops);
+        setJourney({
+          boardingStopId: routeStops[0]?.ID || 0,
+          alightingStopId: routeStops[routeStops.length - 1]?.ID || 0,
+        });
         setBusInfo({
           busName: bus.name || "",
           totalSeats: bus.totalSeats || 0,
           remaining: bus.remainingSeats || 0,
           seatLayout: seatsRes.data.seats || [],
+          layout: seatsRes.data.layout || null,
         });
 
         setBusBookings(
@@ -74,6 +98,20 @@ const BusBooking = () => {
     fetchBusData();
   }, [selectedBus]);
 
+  // The price depends on the seat classes and on how far the passenger travels
+  useEffect(() => {
+    if (!selectedBus || formData.selectedSeats.length === 0) {
+      setQuote(null);
+      return;
+    }
+    axios.post(`http://localhost:8085/api/bus/${selectedBus.ID}/quote`, {
+      selectedSeats: formData.selectedSeats,
+      ...journey,
+    })
+      .then(res => setQuote(res.data.quote))
+      .catch(() => setQuote(null));
+  }, [selectedBus, formData.selectedSeats, journey]);
+
   const validateForm = () => {
     const newErrors = {};
     if (!formData.firstName.trim()) newErrors.firstName = "First Name is required";
@@ -88,13 +126,64 @@ const BusBooking = () => {
     return Object.keys(newErrors).length === 0;
   };
 
-  const handleSeatSelect = (seatNumber) => {
-    setFormData(prev => ({
-      ...prev,
-      selectedSeats: prev.selectedSeats.includes(seatNumber)
-        ? prev.selectedSeats.filter(s => s !== seatNumber)
-        : [...prev.selectedSeats, seatNumber]
-    }));
+  const refreshSeats = async (stopIds = journey) => {
+    const seatsRes = await axios.get(seatsUrl(stopIds));
+    setBusInfo(prev => ({ ...prev, seatLayout: seatsRes.data.seats || [] }));
+  };
+
+  // Seats held for one journey may not be free for another, so changing stops starts over
+  const handleJourneyChange = async (e) => {
+    const { name, value } = e.target;
+    const next = { ...journey, [name]: Number(value) };
+    const boarding = stops.findIndex(s => s.ID === next.boardingStopId);
+    const alighting = stops.findIndex(s => s.ID === next.alightingStopId);
+    if (boarding >= alighting) {
+      toast.error("Please choose a stop after the boarding stop");
+      return;
+    }
+
+    if (holdToken) {
+      await axios.post(
+        `http://localhost:8085/api/bus/${selectedBus.ID}/seats/release`,
+        { holdToken }
+      ).catch(() => {});
+      setHoldToken("");
+    }
+    setFormData(prev => ({ ...prev, selectedSeats: [] }));
+    setJourney(next);
+    idempotencyKey.current = crypto.randomUUID();
+    refreshSeats(next);
+  };
+
+  // Seats are held on the server while the customer fills in the form
+  const handleSeatSelect = async (seatNumber) => {
+    const isSelected = formData.selectedSeats.includes(seatNumber);
+
+    try {
+      if (isSelected) {
+        await axios.post(
+          `http://localhost:8085/api/bus/${selectedBus.ID}/seats/release`,
+          { holdToken, seats: [seatNumber] }
+        );
+      } else {
+        const res = await axios.post(
+          `http://localhost:8085/api/bus/${selectedBus.ID}/seats/hold`,
+          { holdToken, seats: [seatNumber], ...journey }
+        );
+        setHoldToken(res.data.holdToken);
+      }
+
+      idempotencyKey.current = crypto.randomUUID();
+      setFormData(prev => ({
+        ...prev,
+        selectedSeats: isSelected
+          ? prev.selectedSeats.filter(s => s !== seatNumber)
+          : [...prev.selectedSeats, seatNumber]
+      }));
+    } catch (error) {
+      toast.error(error.response?.data?.error || `Seat ${seatNumber} is no longer available`);
+      refreshSeats();
+    }
   };
 
   const handleSubmit = async (e) => {
@@ -109,8 +198,12 @@ const BusBooking = () => {
           lastName: formData.lastName,
           email: formData.email,
           selectedSeats: formData.selectedSeats,
-        }
+          holdToken,
+          ...journey,
+        },
+        { headers: { "Idempotency-Key": idempotencyKey.current } }
       );
+      idempotencyKey.current = crypto.randomUUID();
       console.log("", formData.selectedSeats);
 
       toast.success(`Successfully booked ${formData.selectedSeats.length} seat(s)`);
@@ -122,11 +215,12 @@ const BusBooking = () => {
         email: "",
         selectedSeats: [],
       });
+      setHoldToken("");
 
       // Refresh data
       const [busRes, seatsRes, bookingsRes] = await Promise.all([
         axios.get(`http://localhost:8085/api/bus/${selectedBus.ID}`),
-        axios.get(`http://localhost:8085/api/bus/${selectedBus.ID}/seats`),
+        axios.get(seatsUrl()),
         axios.get(`http://localhost:8085/api/bus/${selectedBus.ID}/bookings`)
       ]);
 
@@ -136,6 +230,7 @@ const BusBooking = () => {
         totalSeats: bus.totalSeats || 0,
         remaining: bus.remainingSeats || 0,
         seatLayout: seatsRes.data.seats || [],
+        layout: seatsRes.data.layout || null,
       });
 
       setBusBookings(bookingsRes.data.bookings || []);
@@ -151,6 +246,7 @@ const BusBooking = () => {
   const handleChange = (e) => {
     const { name, value } = e.target;
     setFormData(prev => ({ ...prev, [name]: value }));
+    idempotencyKey.current = crypto.randomUUID();
   };
 
   return (
@@ -195,14 +291,37 @@ const BusBooking = () => {
             Total Seats: {busInfo.totalSeats} | Remaining Seats: {busInfo.remaining}
           </p>
 
+          {stops.length > 2 && (
+            <div className="journey-select">
+              <label>
+                From{" "}
+                <select name="boardingStopId" value={journey.boardingStopId} onChange={handleJourneyChange}>
+                  {stops.slice(0, -1).map(stop => (
+                    <option key={stop.ID} value={stop.ID}>{stop.name}</option>
+                  ))}
+                </select>
+              </label>
+              <label>
+                To{" "}
+                <select name="alightingStopId" value={journey.alightingStopId} onChange={handleJourneyChange}>
+                  {stops.slice(1).map(stop => (
+                    <option key={stop.ID} value={stop.ID}>{stop.name}</option>
+                  ))}
+                </select>
+              </label>
+            </div>
+          )}
+
           <div className="seat-selection-container">
             <h3>Select Your Seats</h3>
             <SeatMap
               seats={busInfo.seatLayout}
+              layout={busInfo.layout}
               selectedSeats={formData.selectedSeats}
               onSeatSelect={handleSeatSelect}
             />
             {errors.seats && <p className="error-message">{errors.seats}</p>}
+            <PriceQuote quote={quote} />
           </div>
 
           <BookingForm
@@ -1,4 +1,4 @@
-import { useState } from "react";
+import { useEffect, useState } from "react";
 
 function CreateBusForm({ onBusCreated, onCancel }) {
   const [form, setForm] = useState({
@@ -9,11 +9,24 @@ function CreateBusForm({ onBusCreated, onCancel }) {
     departureTime: "",
     arrivalTime: "",
     totalSeats: "",
+    seatLayoutId: "",
+    fare: "",
+    free: false,
   });
   const [error, setError] = useState("");
+  const [layouts, setLayouts] = useState([]);
+
+  // Seat layout templates are optional; without one the bus gets plain rows of seats
+  useEffect(() => {
+    fetch("http://localhost:8085/api/seat-layouts")
+      .then(res => (res.ok ? res.json() : { layouts: [] }))
+      .then(data => setLayouts(data.layouts || []))
+      .catch(() => setLayouts([]));
+  }, []);
 
   const handleChange = e => {
-    setForm({ ...form, [e.target.name]: e.target.value });
+    const { name, type, checked, value } = e.target;
+    setForm({ ...form, [name]: type === "checkbox" ? checked : value });
   };
 
   const today = new Date().toISOString().split("T")[0];
@@ -36,10 +49,21 @@ function CreateBusForm({ onBusCreated, onCancel }) {
       return;
     }
 
+    if (!form.free && !(Number(form.fare) >= 0 && form.fare !== "")) {
+      setError("Enter a fare or mark the trip as free.");
+      return;
+    }
+
     try {
+      const { fare, ...rest } = form;
       const payload = {
-        ...form,
+        ...rest,
+        // Fares are sent in cents; a free trip needs none
+        fares: form.free ? [] : [{ seatClass: "standard", price: Math.round(Number(fare) * 100) }],
         totalSeats: Number(form.totalSeats),
+        seatLayoutId: form.seatLayoutId ? Number(form.seatLayoutId) : null,
+        // Times are read in the route's zone; assume it is the operator's
+        timeZone: Intl.DateTimeFormat().resolvedOptions().timeZone,
       };
       const res = await fetch("http://localhost:8085/api/bus", {
         method: "POST",
@@ -58,6 +82,9 @@ function CreateBusForm({ onBusCreated, onCancel }) {
         departureTime: "",
         arrivalTime: "",
         totalSeats: "",
+        seatLayoutId: "",
+        fare: "",
+        free: false,
       });
     } catch (err) {
       setError(err.message);
@@ -96,7 +123,25 @@ function CreateBusForm({ onBusCreated, onCancel }) {
         onChange={handleChange}
         required
       />
-      <input name="totalSeats" placeholder="Total Seats" type="number" value={form.totalSeats} onChange={handleChange} required />
+      {layouts.length > 0 && (
+        <select name="seatLayoutId" value={form.seatLayoutId} onChange={handleChange}>
+          <option value="">No seat layout</option>
+          {layouts.map(layout => (
+            <option key={layout.ID} value={layout.ID}>
+              {layout.name} ({layout.seats.length} seats)
+            </option>
+          ))}
+        </select>
+      )}
+      {!form.seatLayoutId && (
+        <input name="totalSeats" placeholder="Total Seats" type="number" value={form.totalSeats} onChange={handleChange} required />
+      )}
+      <label>
+        <input name="free" type="checkbox" checked={form.free} onChange={handleChange} /> Free trip
+      </label>
+      {!form.free && (
+        <input name="fare" placeholder="Fare" type="number" min="0" step="0.01" value={form.fare} onChange={handleChange} required />
+      )}
       <div style={{ marginTop: "1rem" }}>
         <button type="submit" className="form-button">Create Bus</button>
         <button type="button" className="form-button" style={{ marginLeft: "1rem", background: "#ccc", color: "#333" }} onClick={onCancel}>Cancel</button>
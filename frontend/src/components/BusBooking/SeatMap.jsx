@@ -1,47 +1,104 @@
 import React from 'react';
 import './SeatMap.css';
 
-const SeatMap = ({ seats = [], selectedSeats = [], onSeatSelect }) => {
+const seatTitle = (seat) => {
+    const details = [seat.class, seat.position].filter(Boolean);
+    if (seat.accessible) details.push('accessible');
+    if (seat.driverAdjacent) details.push('next to the driver');
+    return details.length > 0
+        ? `Seat ${seat.seatNumber} (${details.join(', ')})`
+        : `Seat ${seat.seatNumber}`;
+};
+
+const SeatMap = ({ seats = [], layout = null, selectedSeats = [], onSeatSelect }) => {
     // Ensure seats is always an array
     const safeSeats = Array.isArray(seats) ? seats : [];
-    
-    // Group seats into rows (4 seats per row)
+
+    const renderSeat = seat => (
+        <button
+            key={seat.seatNumber}
+            className={`seat ${seat.status} ${seat.class || ''} ${
+                seat.accessible ? 'accessible' : ''
+            } ${
+                selectedSeats.includes(seat.seatNumber) ? 'selected' : ''
+            }`}
+            onClick={() => onSeatSelect(seat.seatNumber)}
+            disabled={
+                seat.status !== 'available' &&
+                !selectedSeats.includes(seat.seatNumber)
+            }
+            title={seatTitle(seat)}
+        >
+            {seat.seatNumber}
+        </button>
+    );
+
+    // Seats are placed on the grid of the bus layout; aisles and blocked cells stay empty
+    const renderDeck = deck => {
+        const aisles = layout.aisles || [];
+        const rows = [];
+        for (let row = 1; row <= layout.rows; row++) {
+            const cells = [];
+            for (let column = 1; column <= layout.columns; column++) {
+                const seat = safeSeats.find(
+                    s => s.deck === deck && s.row === row && s.column === column
+                );
+                if (seat) {
+                    cells.push(renderSeat(seat));
+                } else {
+                    cells.push(
+                        <span
+                            key={`${row}-${column}`}
+                            className={aisles.includes(column) ? 'seat-gap aisle' : 'seat-gap'}
+                        />
+                    );
+                }
+            }
+            rows.push(<div key={row} className="seat-row">{cells}</div>);
+        }
+        return rows;
+    };
+
+    // Seats the layout does not place fall back to rows of four
+    const unplaced = layout ? safeSeats.filter(s => !s.deck) : safeSeats;
     const rows = [];
-    for (let i = 0; i < safeSeats.length; i += 4) {
-        rows.push(safeSeats.slice(i, i + 4));
+    for (let i = 0; i < unplaced.length; i += 4) {
+        rows.push(unplaced.slice(i, i + 4));
     }
+    const decks = layout ? Array.from({ length: layout.decks }, (_, i) => i + 1) : [];
 
     return (
         <div className="seat-map">
             <div className="bus-front">Front of Bus</div>
-            {rows.length > 0 ? (
-                rows.map((row, rowIndex) => (
-                    <div key={rowIndex} className="seat-row">
-                        {row.map(seat => (
-                            <button
-                                key={seat.seatNumber}
-                                className={`seat ${seat.status} ${
-                                    selectedSeats.includes(seat.seatNumber) ? 'selected' : ''
-                                }`}
-                                onClick={() => onSeatSelect(seat.seatNumber)}
-                                disabled={seat.status !== 'available'}
-                                title={`Seat ${seat.seatNumber}`}
-                            >
-                                {seat.seatNumber}
-                            </button>
-                        ))}
-                    </div>
-                ))
+            {safeSeats.length > 0 ? (
+                <>
+                    {decks.map(deck => (
+                        <div key={deck} className="seat-deck">
+                            {decks.length > 1 && (
+                                <h4>{deck === 1 ? 'Lower Deck' : 'Upper Deck'}</h4>
+                            )}
+                            {renderDeck(deck)}
+                        </div>
+                    ))}
+                    {rows.map((row, rowIndex) => (
+                        <div key={rowIndex} className="seat-row">
+                            {row.map(renderSeat)}
+                        </div>
+                    ))}
+                </>
             ) : (
                 <p>No seats available</p>
             )}
             <div className="seat-legend">
                 <div><span className="legend available"></span> Available</div>
                 <div><span className="legend booked"></span> Booked</div>
+                <div><span className="legend held"></span> Held</div>
                 <div><span className="legend selected"></span> Selected</div>
+                <div><span className="legend premium"></span> Premium</div>
+                <div><span className="legend accessible"></span> Accessible</div>
             </div>
         </div>
     );
 };
 
-export default SeatMap;
\ No newline at end of file
+export default SeatMap;
@@ -1,18 +1,22 @@
-import React, { useState, useEffect } from 'react';
+import React, { useState, useEffect, useRef } from 'react';
 import axios from 'axios';
 import RecentBookings from '../RecentBookings';
 import ConferencePanel from './ConferencePanel';
 import CreateConferenceForm from './CreateConferenceForm';
 import BookingForm from '../BookingForm';
+import PriceQuote from '../PriceQuote';
 
 const ConferenceBooking = () => {
   const [conferences, setConferences] = useState([]);
   const [selectedConference, setSelectedConference] = useState(null);
   const [showCreateForm, setShowCreateForm] = useState(false);
-  const [conferenceInfo, setConferenceInfo] = useState({ title: '', totalTickets: 0, remaining: 0 });
+  const [conferenceInfo, setConferenceInfo] = useState({ title: '', totalTickets: 0, remaining: 0, tiers: [] });
   const [conferenceBookings, setConferenceBookings] = useState([]);
-  const [formData, setFormData] = useState({ firstName: '', lastName: '', email: '', seats: '', tickets: '' });
+  const [formData, setFormData] = useState({ firstName: '', lastName: '', email: '', seats: '', tickets: '', tier: '' });
+  const [quote, setQuote] = useState(null);
   const [errors, setErrors] = useState({});
+  // Reused when the same booking is retried, so the server never books it twice
+  const idempotencyKey = useRef(crypto.randomUUID());
 
   useEffect(() => {
     axios.get('http://localhost:8085/api/conferences').then(res => {
@@ -29,13 +33,29 @@ const ConferenceBooking = () => {
         title: conf.title || '',
         totalTickets: conf.totalTickets || '',
         remaining: conf.remainingTickets || '',
+        tiers: conf.tiers || [],
       });
+      setFormData(prev => ({ ...prev, tier: conf.tiers?.[0]?.name || '' }));
     });
     axios.get(`http://localhost:8085/api/conference/${selectedConference.ID}/bookings`).then(res => {
       setConferenceBookings(Array.isArray(res.data.bookings) ? res.data.bookings : []);
     });
   }, [selectedConference]);
 
+  useEffect(() => {
+    const tickets = Number(formData.tickets || formData.seats);
+    if (!selectedConference || !(tickets > 0)) {
+      setQuote(null);
+      return;
+    }
+    axios.post(`http://localhost:8085/api/conference/${selectedConference.ID}/quote`, {
+      tickets,
+      tier: formData.tier,
+    })
+      .then(res => setQuote(res.data.quote))
+      .catch(() => setQuote(null));
+  }, [selectedConference, formData.tickets, formData.seats, formData.tier]);
+
   const validateForm = () => {
     const newErrors = {};
     if (!formData.firstName.trim()) newErrors.firstName = 'First Name is required';
@@ -51,9 +71,14 @@ const ConferenceBooking = () => {
     if (!validateForm()) return;
     try {
       const payload = { ...formData, tickets: Number(formData.tickets), conferenceId: selectedConference.ID };
-      const response = await axios.post(`http://localhost:8085/api/conference/${selectedConference.ID}/book`, payload);
+      const response = await axios.post(
+        `http://localhost:8085/api/conference/${selectedConference.ID}/book`,
+        payload,
+        { headers: { 'Idempotency-Key': idempotencyKey.current } }
+      );
+      idempotencyKey.current = crypto.randomUUID();
       alert(response.data.message);
-      setFormData({ firstName: '', lastName: '', email: '', seats: '', tickets: '' });
+      setFormData(prev => ({ firstName: '', lastName: '', email: '', seats: '', tickets: '', tier: prev.tier }));
       setConferenceInfo(prev => ({ ...prev, remaining: response.data.remaining }));
       const bookingsRes = await axios.get(`http://localhost:8085/api/conference/${selectedConference.ID}/bookings`);
       setConferenceBookings(Array.isArray(bookingsRes.data.bookings) ? bookingsRes.data.bookings : []);
@@ -70,6 +95,7 @@ const ConferenceBooking = () => {
   const handleChange = (e) => {
     const { name, value } = e.target;
     setFormData({ ...formData, [name]: value });
+    idempotencyKey.current = crypto.randomUUID();
   };
 
   return (
@@ -102,6 +128,17 @@ const ConferenceBooking = () => {
           <p className="remaining-tickets">
             Total Tickets: {conferenceInfo.totalTickets} | Remaining Tickets: {conferenceInfo.remaining}
           </p>
+          {conferenceInfo.tiers.length > 0 && (
+            <label className="tier-select">
+              Ticket type{' '}
+              <select name="tier" value={formData.tier} onChange={handleChange}>
+                {conferenceInfo.tiers.map(tier => (
+                  <option key={tier.name} value={tier.name}>{tier.name}</option>
+                ))}
+              </select>
+            </label>
+          )}
+          <PriceQuote quote={quote} />
          <BookingForm
 
             formData={formData}
@@ -34,6 +34,8 @@ function CreateConferenceForm({ onConferenceCreated, onCancel }) {
             const payload = {
                 ...form,
                 totalTickets: Number(form.totalTickets),
+                // Dates are read in the venue's zone; assume it is the organiser's
+                timeZone: Intl.DateTimeFormat().resolvedOptions().timeZone,
             };
             const res = await fetch('http://localhost:8085/api/conferences', {
                 method: 'POST',
@@ -0,0 +1,184 @@
+import React, { useCallback, useEffect, useState } from 'react';
+import { useSearchParams } from 'react-router-dom';
+import axios from 'axios';
+import { ToastContainer, toast } from 'react-toastify';
+import 'react-toastify/dist/ReactToastify.css';
+
+const API = 'http://localhost:8085';
+
+// Guests reach this page from the link in their email; the token in the link
+// authorizes the /api/guest endpoints through the X-Guest-Token header
+function GuestBookings() {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get('token');
+  const [bookings, setBookings] = useState(null);
+  const [error, setError] = useState('');
+  const [email, setEmail] = useState('');
+  const [lookupSent, setLookupSent] = useState(false);
+
+  const headers = { 'X-Guest-Token': token };
+
+  const loadBookings = useCallback(() => {
+    if (!token) return;
+    axios.get(`${API}/api/guest/bookings`, { headers: { 'X-Guest-Token': token } })
+      .then(res => {
+        setBookings(res.data);
+        setError('');
+      })
+      .catch(err => setError(err.response?.data?.error || 'Failed to load your bookings'));
+  }, [token]);
+
+  useEffect(() => {
+    loadBookings();
+  }, [loadBookings]);
+
+  const requestLink = async (e) => {
+    e.preventDefault();
+    try {
+      await axios.post(`${API}/api/guest/lookup`, { email });
+      setLookupSent(true);
+    } catch (err) {
+      toast.error(err.response?.data?.error || 'Failed to send link');
+    }
+  };
+
+  const cancelBooking = async (cancelUrl) => {
+    if (!window.confirm('Cancel this booking?')) return;
+    try {
+      await axios.post(`${API}${cancelUrl}`, null, { headers });
+      toast.success('Booking cancelled');
+      loadBookings();
+    } catch (err) {
+      toast.error(err.response?.data?.error || 'Failed to cancel booking');
+    }
+  };
+
+  // The PDF needs the guest header, so it is fetched and handed to the browser as a file
+  const downloadTicket = async (ticket) => {
+    try {
+      const res = await axios.get(`${API}${ticket.pdfUrl}`, { headers, responseType: 'blob' });
+      const url = URL.createObjectURL(res.data);
+      const link = document.createElement('a');
+      link.href = url;
+      link.download = `ticket-${ticket.id}.pdf`;
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch {
+      toast.error('Failed to download ticket');
+    }
+  };
+
+  const renderTickets = (tickets) => (
+    (tickets || []).map(t => (
+      <div key={t.id}>
+        {t.seatNumber ? `Seat ${t.seatNumber}` : t.attendeeName || 'Unassigned'}
+        {t.revoked ? ' (cancelled)' : (
+          <button type="button" onClick={() => downloadTicket(t)}>Download PDF</button>
+        )}
+      </div>
+    ))
+  );
+
+  const renderBusRows = (items) => (
+    items.length === 0 ? (
+      <tr><td colSpan="5">No bus bookings.</td></tr>
+    ) : items.map(({ booking, bus, tickets, cancelUrl }) => (
+      <tr key={booking.ID}>
+        <td>{bus?.vehicle?.name || booking.busName}</td>
+        <td>{booking.boardingStop} → {booking.alightingStop}</td>
+        <td>{bus?.departsAt ? new Date(bus.departsAt).toLocaleString() : '-'}</td>
+        <td>{renderTickets(tickets)}</td>
+        <td>
+          {booking.status}
+          {cancelUrl && (
+            <button type="button" onClick={() => cancelBooking(cancelUrl)}>Cancel</button>
+          )}
+        </td>
+      </tr>
+    ))
+  );
+
+  const renderConferenceRows = (items) => (
+    items.length === 0 ? (
+      <tr><td colSpan="5">No conference bookings.</td></tr>
+    ) : items.map(({ booking, conference, tickets, cancelUrl }) => (
+      <tr key={booking.ID}>
+        <td>{conference?.title || booking.conferenceName}</td>
+        <td>{conference?.location || '-'}</td>
+        <td>{conference?.startsAt ? new Date(conference.startsAt).toLocaleDateString() : '-'}</td>
+        <td>{renderTickets(tickets)}</td>
+        <td>
+          {booking.status}
+          {cancelUrl && (
+            <button type="button" onClick={() => cancelBooking(cancelUrl)}>Cancel</button>
+          )}
+        </td>
+      </tr>
+    ))
+  );
+
+  if (!token) {
+    return (
+      <div>
+        <h1 className="header">My Bookings</h1>
+        {lookupSent ? (
+          <p>If we have bookings for {email}, a link to them is on its way.</p>
+        ) : (
+          <form onSubmit={requestLink}>
+            <p>Enter the email you booked with and we will send you a link to your bookings.</p>
+            <input type="email" value={email} onChange={e => setEmail(e.target.value)} required />
+            <button type="submit">Send link</button>
+          </form>
+        )}
+        <ToastContainer />
+      </div>
+    );
+  }
+
+  return (
+    <div>
+      <h1 className="header">My Bookings</h1>
+      {error && <p className="error">{error}</p>}
+      {bookings && (
+        <>
+          <p>Bookings made with {bookings.email}</p>
+
+          <h2>Bus Trips</h2>
+          <table border="1" cellPadding="8" style={{ width: "100%", marginBottom: "2rem" }}>
+            <thead>
+              <tr>
+                <th>Bus</th>
+                <th>Journey</th>
+                <th>Departure</th>
+                <th>Tickets</th>
+                <th>Status</th>
+              </tr>
+            </thead>
+            <tbody>
+              {renderBusRows([...bookings.bus.upcoming, ...bookings.bus.past])}
+            </tbody>
+          </table>
+
+          <h2>Conferences</h2>
+          <table border="1" cellPadding="8" style={{ width: "100%" }}>
+            <thead>
+              <tr>
+                <th>Conference</th>
+                <th>Location</th>
+                <th>Starts</th>
+                <th>Tickets</th>
+                <th>Status</th>
+              </tr>
+            </thead>
+            <tbody>
+              {renderConferenceRows([...bookings.conferences.upcoming, ...bookings.conferences.past])}
+            </tbody>
+          </table>
+        </>
+      )}
+      <ToastContainer />
+    </div>
+  );
+}
+
+export default GuestBookings;
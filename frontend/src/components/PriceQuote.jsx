@@ -0,0 +1,32 @@
+// Amounts come from the server in minor units (cents)
+export const formatMoney = (amount, currency) =>
+  `${currency} ${(amount / 100).toFixed(2)}`;
+
+function PriceQuote({ quote }) {
+  if (!quote || quote.lines.length === 0) return null;
+
+  return (
+    <div className="price-quote">
+      <table>
+        <tbody>
+          {quote.lines.map((line, i) => (
+            <tr key={i}>
+              <td>{line.quantity > 1 ? `${line.quantity} × ${line.description}` : line.description}</td>
+              <td>{formatMoney(line.subtotal, quote.currency)}</td>
+            </tr>
+          ))}
+          <tr>
+            <td>Tax ({quote.taxBasisPoints / 100}%)</td>
+            <td>{formatMoney(quote.tax, quote.currency)}</td>
+          </tr>
+          <tr>
+            <th>Total</th>
+            <th>{formatMoney(quote.total, quote.currency)}</th>
+          </tr>
+        </tbody>
+      </table>
+    </div>
+  );
+}
+
+export default PriceQuote;
@@ -34,6 +34,13 @@ function Sidebar() {
       >
         Recent Bookings
       </NavLink>
+      <NavLink 
+        to="/my-bookings" 
+        className={({ isActive }) => 
+          "sidebar-link" + (isActive ? " active" : "")}
+      >
+        My Bookings
+      </NavLink>
     </div>
   );
 }